@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// AccessRule controls who can access an URI path prefix.
+// A user is written as username, @group for the members of a group,
+// or * for any authenticated user.
+type AccessRule struct {
+	// Anonymous allows reading without authentication
+	Anonymous bool
+	// Read is the users who can read, empty is any authenticated user
+	Read []string
+	// Upload is the users who can upload, empty is the same as Read
+	Upload []string
+	// Manage is the users who can create, rename, move and delete files, empty is the same as Upload
+	Manage []string
+}
+
+type accessMode int
+
+const (
+	accessRead accessMode = iota
+	accessUpload
+	accessManage
+)
+
+// pathHasPrefix reports whether path is prefix or below prefix,
+// matching on path segment boundaries
+func pathHasPrefix(path, prefix string) bool {
+	prefix = strings.TrimRight(prefix, "/")
+	if len(prefix) == 0 {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// accessIgnoreCase is true on the case-insensitive filesystems, where /share/SECRET is the same
+// file as /share/secret, so the access rules must match it
+var accessIgnoreCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// accessHasPrefix is pathHasPrefix for the access rules, it ignores case if accessIgnoreCase
+func accessHasPrefix(path, prefix string) bool {
+	if accessIgnoreCase {
+		return pathHasPrefix(strings.ToLower(path), strings.ToLower(prefix))
+	}
+	return pathHasPrefix(path, prefix)
+}
+
+// findAccessRule returns the rule of the longest prefix which matches path
+func findAccessRule(path string) *AccessRule {
+	var rule *AccessRule
+	matched := -1
+	for k, v := range config.Access {
+		if len(k) > matched && accessHasPrefix(path, k) {
+			rule = v
+			matched = len(k)
+		}
+	}
+	return rule
+}
+
+func (rule *AccessRule) users(mode accessMode) []string {
+	if mode == accessManage && len(rule.Manage) > 0 {
+		return rule.Manage
+	}
+	if mode != accessRead && len(rule.Upload) > 0 {
+		return rule.Upload
+	}
+	return rule.Read
+}
+
+// isAllowed reports whether user can access path, user is empty for anonymous.
+// The access rules need basic authentication, so all accesses are allowed without it.
+func isAllowed(path, user string, mode accessMode) bool {
+	if !enableBasicAuth {
+		return true
+	}
+	rule := findAccessRule(path)
+	if rule == nil {
+		return len(user) > 0
+	}
+	if len(user) == 0 {
+		return mode == accessRead && rule.Anonymous
+	}
+	allowed := rule.users(mode)
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, v := range allowed {
+		switch {
+		case v == "*" || v == user:
+			return true
+		case strings.HasPrefix(v, "@"):
+			for _, member := range config.Groups[v[1:]] {
+				if member == user {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isTreeAllowed reports whether user can access path and the access rules below path,
+// it is checked for the operations on a whole directory tree, e.g. deleting or moving a directory
+func isTreeAllowed(path, user string, mode accessMode) bool {
+	if !isAllowed(path, user, mode) {
+		return false
+	}
+	for k := range config.Access {
+		if accessHasPrefix(k, path) && !isAllowed(k, user, mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize responses 401 for anonymous or 403 for user if path is not allowed
+func authorize(ctx *fasthttp.RequestCtx, path, user string, mode accessMode) bool {
+	if isAllowed(path, user, mode) {
+		return true
+	}
+	if len(user) == 0 {
+		unauthorized(ctx)
+		return false
+	}
+	statusCode := fasthttp.StatusForbidden
+	ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+	return false
+}
+
+func unauthorized(ctx *fasthttp.RequestCtx) {
+	statusCode := fasthttp.StatusUnauthorized
+	ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+	ctx.Response.Header.Set("WWW-Authenticate", "Basic realm=Restricted")
+}
+
+func printAccessRules() {
+	if len(config.Access) == 0 {
+		return
+	}
+	if !enableBasicAuth {
+		log.Println("Access rules are ignored without basic authentication")
+		return
+	}
+	log.Printf("Have %d access rule(s):\n", len(config.Access))
+	for k, v := range config.Access {
+		if v == nil {
+			v = &AccessRule{}
+		}
+		log.Printf("   %s anonymous: %v, read: %v, upload: %v, manage: %v\n", k, v.Anonymous, v.Read, v.Upload, v.Manage)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestIsTreeAllowed(t *testing.T) {
+	defer func(access map[string]*AccessRule, auth bool) {
+		config.Access, enableBasicAuth = access, auth
+	}(config.Access, enableBasicAuth)
+	enableBasicAuth = true
+	config.Access = map[string]*AccessRule{
+		"/share":         {},
+		"/share/secret/": {Read: []string{"alice"}},
+		"/share/pub":     {Manage: []string{"alice"}},
+	}
+
+	tests := []struct {
+		path string
+		user string
+		mode accessMode
+		want bool
+	}{
+		{"/share", "bob", accessRead, false},
+		{"/share", "alice", accessRead, true},
+		{"/share/pub", "bob", accessRead, true},
+		{"/share/pub", "bob", accessManage, false},
+		{"/share/pub", "alice", accessManage, true},
+		{"/share/pub/a.txt", "bob", accessUpload, true},
+		{"/share/secret", "bob", accessRead, false},
+		{"/share/secretx", "bob", accessRead, true},
+		{"/", "bob", accessRead, false},
+		{"/", "alice", accessManage, true},
+		{"/share", "", accessRead, false},
+	}
+	for _, tt := range tests {
+		if got := isTreeAllowed(tt.path, tt.user, tt.mode); got != tt.want {
+			t.Errorf("isTreeAllowed(%q, %q, %v) = %v, want %v", tt.path, tt.user, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestAccessIgnoreCase(t *testing.T) {
+	defer func(access map[string]*AccessRule, auth, ignoreCase bool) {
+		config.Access, enableBasicAuth, accessIgnoreCase = access, auth, ignoreCase
+	}(config.Access, enableBasicAuth, accessIgnoreCase)
+	enableBasicAuth = true
+	config.Access = map[string]*AccessRule{
+		"/share":        {Anonymous: true},
+		"/share/secret": {Read: []string{"alice"}},
+	}
+
+	tests := []struct {
+		path       string
+		ignoreCase bool
+		want       bool
+	}{
+		{"/share/secret/a.txt", false, false},
+		{"/share/SECRET/a.txt", false, true},
+		{"/share/secret/a.txt", true, false},
+		{"/share/SECRET/a.txt", true, false},
+		{"/Share/Secret", true, false},
+		{"/share/secretx", true, true},
+	}
+	for _, tt := range tests {
+		accessIgnoreCase = tt.ignoreCase
+		if got := isAllowed(tt.path, "bob", accessRead); got != tt.want {
+			t.Errorf("ignoreCase %v: isAllowed(%q, bob) = %v, want %v", tt.ignoreCase, tt.path, got, tt.want)
+		}
+	}
+	accessIgnoreCase = true
+	if isTreeAllowed("/SHARE", "bob", accessManage) {
+		t.Error("isTreeAllowed(\"/SHARE\", bob) = true, want false")
+	}
+}
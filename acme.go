@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeChallengePath is the URI path prefix of the ACME HTTP-01 challenges
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// acmeRetryInterval is the time to use the configured certificates after ACME failed for a host
+const acmeRetryInterval = 10 * time.Minute
+
+// acmeDefaultCacheDir is the directory of the ACME account and certificates if acmecachedir is not given
+const acmeDefaultCacheDir = "acme-cache"
+
+var (
+	acmeManager *autocert.Manager
+	// acmeDomains is config.ACMEDomains in lower case, it is used in TLS handshakes without locking config
+	acmeDomains map[string]bool
+	// acmeChallengeHandler answers the HTTP-01 challenges on both listeners
+	acmeChallengeHandler fasthttp.RequestHandler
+	// acmeFailures is the last failed time of the hosts
+	acmeFailures   = make(map[string]time.Time)
+	acmeFailuresMu sync.Mutex
+)
+
+// newACMEManager returns the manager which obtains and renews the certificates of config.ACMEDomains
+// from config.ACMEDirectoryURL, default is Let's Encrypt.
+// config.ACMECAFile is the CA to trust the directory, e.g. the CA of a test server like Pebble.
+func newACMEManager() (*autocert.Manager, error) {
+	httpClient := http.DefaultClient
+	if len(config.ACMECAFile) > 0 {
+		pool, err := loadCertPool(config.ACMECAFile)
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCacheDir()),
+		HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+		Email:      config.ACMEEmail,
+		Client: &acme.Client{
+			DirectoryURL: config.ACMEDirectoryURL,
+			HTTPClient:   httpClient,
+		},
+	}, nil
+}
+
+// loadCertPool returns the pool of the PEM certificates in file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New(file + ": no certificate")
+	}
+	return pool, nil
+}
+
+func acmeCacheDir() string {
+	if len(config.ACMECacheDir) > 0 {
+		return config.ACMECacheDir
+	}
+	return acmeDefaultCacheDir
+}
+
+func checkACMEConfig(cfg *Config) error {
+	for _, domain := range cfg.ACMEDomains {
+		if len(domain) == 0 || strings.ContainsAny(domain, "/:*") {
+			return errors.New("bad acmedomains " + domain)
+		}
+	}
+	if len(cfg.ACMEDirectoryURL) > 0 {
+		if u, err := url.Parse(cfg.ACMEDirectoryURL); err != nil || u.Scheme != "https" {
+			return errors.New("acmedirectoryurl should be an https URL")
+		}
+	}
+	if len(cfg.ACMECAFile) > 0 {
+		if _, err := loadCertPool(cfg.ACMECAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupACME creates the ACME manager if config.AddrTLS and config.ACMEDomains are given
+func setupACME() error {
+	if len(config.AddrTLS) == 0 || len(config.ACMEDomains) == 0 {
+		return nil
+	}
+	m, err := newACMEManager()
+	if err != nil {
+		return err
+	}
+	acmeManager = m
+	acmeDomains = make(map[string]bool)
+	for _, domain := range config.ACMEDomains {
+		acmeDomains[strings.ToLower(domain)] = true
+	}
+	h := m.HTTPHandler(nil)
+	acmeChallengeHandler = fasthttpadaptor.NewFastHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the host policy does not accept the port, which is not 80 for the test servers
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			r.Host = host
+		}
+		h.ServeHTTP(w, r)
+	}))
+	return nil
+}
+
+// isACMEChallengeHello reports whether the handshake is a TLS-ALPN-01 challenge of the ACME server
+func isACMEChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// getACMECertificate returns the ACME certificate of the host, ok is false to use the configured certificates.
+// After a failure, the configured certificates are used for acmeRetryInterval.
+func getACMECertificate(hello *tls.ClientHelloInfo) (cert *tls.Certificate, ok bool, err error) {
+	if acmeManager == nil {
+		return nil, false, nil
+	}
+	if isACMEChallengeHello(hello) {
+		cert, err := acmeManager.GetCertificate(hello)
+		return cert, true, err
+	}
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if !acmeDomains[host] {
+		return nil, false, nil
+	}
+	acmeFailuresMu.Lock()
+	failed, isFailed := acmeFailures[host]
+	acmeFailuresMu.Unlock()
+	if isFailed && time.Since(failed) < acmeRetryInterval {
+		return nil, false, nil
+	}
+	cert, err = acmeManager.GetCertificate(hello)
+	acmeFailuresMu.Lock()
+	defer acmeFailuresMu.Unlock()
+	if err != nil {
+		acmeFailures[host] = time.Now()
+		log.Printf("ACME certificate for %s failed, use the configured certificates: %v\n", host, err)
+		return nil, false, nil
+	}
+	delete(acmeFailures, host)
+	return cert, true, nil
+}
+
+func printACME() {
+	if acmeManager == nil {
+		return
+	}
+	log.Println("ACMEDomains:", config.ACMEDomains)
+	directoryURL := config.ACMEDirectoryURL
+	if len(directoryURL) == 0 {
+		directoryURL = autocert.DefaultACMEDirectory
+	}
+	log.Println("ACMEDirectoryURL:", directoryURL)
+	log.Println("ACMECacheDir:", acmeCacheDir())
+}
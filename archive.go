@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+
+	"github.com/valyala/fasthttp"
+)
+
+// archiveFormats are the supported formats of ?archive= and their content types
+var archiveFormats = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+// archiveHandler streams the directory of URI path as a zip or tar.gz archive without staging to disk.
+// The entries of other mapped paths, hidden files, symlinked directories, symlinks not allowed
+// and the paths the user can not read are skipped.
+func archiveHandler(ctx *fasthttp.RequestCtx, rt *route, path, localpath, user string) {
+	format := string(ctx.QueryArgs().Peek("archive"))
+	contentType, ok := archiveFormats[format]
+	if !ok {
+		ctx.Error("archive should be zip or tar.gz", fasthttp.StatusBadRequest)
+		return
+	}
+	if !rt.mount.listingEnabled() {
+		statusCode := fasthttp.StatusForbidden
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+
+	name := pathpkg.Base(path)
+	if name == "/" {
+		name = "root"
+	}
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	// the archive is compressed, do not compress it again by config.Compress
+	ctx.Response.Header.Set("Content-Encoding", "identity")
+	logInfo(0, "%s | %s | Download archive %s", ctx.RemoteIP(), user, path)
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		var err error
+		if format == "zip" {
+			err = writeZip(w, rt, path, localpath, user)
+		} else {
+			err = writeTarGz(w, rt, path, localpath, user)
+		}
+		if err != nil {
+			logInfo(fasthttp.StatusInternalServerError, "Archive %s failed: %s", path, err.Error())
+		}
+	})
+}
+
+// walkArchive walks the files of localpath which can be put into the archive of URI path,
+// name is the slash separated path relative to localpath
+func walkArchive(rt *route, path, localpath, user string, fn func(name, local string, fi os.FileInfo) error) error {
+	return filepath.Walk(localpath, func(local string, fi os.FileInfo, err error) error {
+		if err != nil || local == localpath {
+			// skip the unreadable entries
+			return nil
+		}
+		rel, err := filepath.Rel(localpath, local)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if fi, ok := archiveFileInfo(rt, pathpkg.Join(path, name), local, user, fi); ok {
+			return fn(name, local, fi)
+		} else if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// archiveFileInfo returns the info of file to put in the archive, ok is false if it is skipped,
+// it is called after the request handler, so the config is locked for reading here
+func archiveFileInfo(rt *route, uri, local, user string, fi os.FileInfo) (os.FileInfo, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	if fi.Mode()&os.ModeSymlink != 0 && rt.mount.checkSymlinks(local) == nil {
+		// the symlinked files are put as regular files
+		if target, err := os.Stat(local); err == nil && target.Mode().IsRegular() {
+			fi = target
+		}
+	}
+	if !fi.Mode().IsRegular() && !fi.IsDir() ||
+		routes.match(uri) != rt || rt.mount.isHidden(rt.rel(uri)) || !isAllowed(uri, user, accessRead) {
+		return fi, false
+	}
+	return fi, true
+}
+
+func writeZip(w io.Writer, rt *route, path, localpath, user string) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(rt, path, localpath, user, func(name, local string, fi os.FileInfo) error {
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if fi.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFile(fw, local)
+	})
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeTarGz(w io.Writer, rt *route, path, localpath, user string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkArchive(rt, path, localpath, user, func(name, local string, fi os.FileInfo) error {
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		// the size of file is written in the header, copy the same size
+		file, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(tw, file, header.Size)
+		return err
+	})
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func copyFile(w io.Writer, fn string) error {
+	file, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
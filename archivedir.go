@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// archiveExts are the extensions of archives which can be browsed as directories
+var archiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+func isArchiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveMember is a file or directory in an archive
+type archiveMember struct {
+	// name is the slash separated path without leading and trailing slashes
+	name string
+	info os.FileInfo
+}
+
+// archiveDirInfo is the info of a directory which is not stored in the archive but has members
+type archiveDirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi *archiveDirInfo) Name() string       { return fi.name }
+func (fi *archiveDirInfo) Size() int64        { return 0 }
+func (fi *archiveDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi *archiveDirInfo) ModTime() time.Time { return fi.modTime }
+func (fi *archiveDirInfo) IsDir() bool        { return true }
+func (fi *archiveDirInfo) Sys() interface{}   { return nil }
+
+// memberReader reads a member of archive, and closes the archive at last
+type memberReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *memberReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// findArchive returns the local archive file and the member path in it if URI path is inside an archive,
+// e.g. /builds/release.zip/bin/tool is bin/tool in release.zip, and /builds/release.zip/ is the root of it
+func findArchive(rt *route, path string) (archive, member string, ok bool) {
+	names := strings.Split(strings.Trim(rt.rel(path), "/"), "/")
+	local := rt.mount.Root
+	for i, name := range names {
+		if len(name) == 0 {
+			return
+		}
+		local = filepath.Join(local, name)
+		fi, err := os.Stat(local)
+		if err != nil {
+			return
+		}
+		if fi.IsDir() {
+			continue
+		}
+		if fi.Mode().IsRegular() && isArchiveName(name) && (i < len(names)-1 || strings.HasSuffix(path, "/")) {
+			return local, strings.Join(names[i+1:], "/"), true
+		}
+		return
+	}
+	return
+}
+
+// archiveMemberHandler serves the member of archive, it lists the directory or streams the file with range support
+func archiveMemberHandler(ctx *fasthttp.RequestCtx, rt *route, path, archive, member string) {
+	if len(member) > 0 {
+		r, info, err := openArchiveMember(archive, member)
+		if err == nil {
+			serveArchiveMember(ctx, r, info)
+			return
+		}
+		if !os.IsNotExist(err) {
+			logInfo(fasthttp.StatusInternalServerError, "Read %s in %s failed: %s", member, archive, err.Error())
+			statusCode := fasthttp.StatusInternalServerError
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+			return
+		}
+	}
+
+	members, err := readArchive(archive)
+	if err != nil {
+		logInfo(fasthttp.StatusInternalServerError, "Read %s failed: %s", archive, err.Error())
+		statusCode := fasthttp.StatusInternalServerError
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	ff, ok := archiveDir(members, member, archive)
+	if !ok {
+		statusCode := fasthttp.StatusNotFound
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	if !rt.mount.listingEnabled() {
+		statusCode := fasthttp.StatusForbidden
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	ff = filterHidden(rt.mount, rt.rel(path), ff)
+	writeListing(ctx, newListingPage(newDirListing("/"+strings.Trim(path, "/"), ff)))
+	setCacheControl(ctx, rt.mount)
+}
+
+// serveArchiveMember streams the member file, the range is served by skipping the data before it,
+// because the compressed members can not seek
+func serveArchiveMember(ctx *fasthttp.RequestCtx, r *memberReader, info os.FileInfo) {
+	size := int(info.Size())
+	start, end := 0, size-1
+	ctx.Response.Header.Set("Accept-Ranges", "bytes")
+	if byteRange := ctx.Request.Header.Peek("Range"); len(byteRange) > 0 && size > 0 {
+		var err error
+		if start, end, err = fasthttp.ParseByteRange(byteRange, size); err != nil {
+			r.Close()
+			statusCode := fasthttp.StatusRequestedRangeNotSatisfiable
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+			ctx.Response.Header.Set("Content-Range", "bytes */"+strconv.Itoa(size))
+			return
+		}
+		if _, err := io.CopyN(ioutil.Discard, r.Reader, int64(start)); err != nil {
+			r.Close()
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		ctx.Response.Header.SetContentRange(start, end, size)
+		ctx.SetStatusCode(fasthttp.StatusPartialContent)
+	}
+
+	ext := strings.ToLower(pathpkg.Ext(info.Name()))
+	mimeType := staticFileGetMimeType(ext)
+	if len(mimeType) == 0 {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if len(mimeType) == 0 {
+		mimeType = "application/octet-stream"
+	}
+	ctx.SetContentType(mimeType)
+	ctx.Response.Header.SetBytesV("Last-Modified", fasthttp.AppendHTTPDate(nil, info.ModTime()))
+	r.Reader = io.LimitReader(r.Reader, int64(end-start+1))
+	ctx.SetBodyStream(r, end-start+1)
+}
+
+func cleanMemberName(name string) string {
+	return strings.Trim(pathpkg.Clean("/"+name), "/")
+}
+
+// openTar opens the tar or tar.gz archive
+func openTar(archive string) (*tar.Reader, []io.Closer, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	closers := []io.Closer{file}
+	var r io.Reader = file
+	name := strings.ToLower(archive)
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		closers = append(closers, gr)
+		r = gr
+	}
+	return tar.NewReader(r), closers, nil
+}
+
+// openArchiveMember opens the file member of archive, it returns os.ErrNotExist if the file is not found
+func openArchiveMember(archive, member string) (*memberReader, os.FileInfo, error) {
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range zr.File {
+			if cleanMemberName(f.Name) == member && f.Mode().IsRegular() {
+				rc, err := f.Open()
+				if err != nil {
+					zr.Close()
+					return nil, nil, err
+				}
+				return &memberReader{Reader: rc, closers: []io.Closer{zr, rc}}, f.FileInfo(), nil
+			}
+		}
+		zr.Close()
+		return nil, nil, os.ErrNotExist
+	}
+
+	tr, closers, err := openTar(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &memberReader{Reader: tr, closers: closers}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			r.Close()
+			if err == io.EOF {
+				err = os.ErrNotExist
+			}
+			return nil, nil, err
+		}
+		if cleanMemberName(hdr.Name) == member && hdr.FileInfo().Mode().IsRegular() {
+			return r, hdr.FileInfo(), nil
+		}
+	}
+}
+
+// readArchive returns the files and directories in archive
+func readArchive(archive string) ([]archiveMember, error) {
+	var members []archiveMember
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if fi := f.FileInfo(); fi.Mode().IsRegular() || fi.IsDir() {
+				members = append(members, archiveMember{name: cleanMemberName(f.Name), info: fi})
+			}
+		}
+		return members, nil
+	}
+
+	tr, closers, err := openTar(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer (&memberReader{closers: closers}).Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi := hdr.FileInfo(); fi.Mode().IsRegular() || fi.IsDir() {
+			members = append(members, archiveMember{name: cleanMemberName(hdr.Name), info: fi})
+		}
+	}
+}
+
+// archiveDir returns the files and directories in the directory dir of archive members,
+// ok is false if dir is not a directory, the directories which are not stored are added
+func archiveDir(members []archiveMember, dir, archive string) (ff []os.FileInfo, ok bool) {
+	var modTime time.Time
+	if fi, err := os.Stat(archive); err == nil {
+		modTime = fi.ModTime()
+	}
+	ok = len(dir) == 0
+	prefix := dir + "/"
+	if ok {
+		prefix = ""
+	}
+	children := make(map[string]int)
+	for _, m := range members {
+		if m.name == dir {
+			if !m.info.IsDir() {
+				return nil, false
+			}
+			ok = true
+			continue
+		}
+		if !strings.HasPrefix(m.name, prefix) {
+			continue
+		}
+		ok = true
+		name := m.name[len(prefix):]
+		info := m.info
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name = name[:i]
+			info = &archiveDirInfo{name: name, modTime: modTime}
+		}
+		if i, exist := children[name]; exist {
+			if info == m.info {
+				ff[i] = info
+			}
+			continue
+		}
+		children[name] = len(ff)
+		ff = append(ff, info)
+	}
+	return ff, ok
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// userStore holds the accounts from config.Users, config.HtpasswdFile and
+// the legacy config.Username/config.Password pair
+type userStore struct {
+	mu       sync.RWMutex
+	hashes   map[string]string
+	verified map[[sha256.Size]byte]string
+}
+
+var users = &userStore{}
+
+// set replaces all accounts, the cache of verified credentials is dropped
+func (s *userStore) set(hashes map[string]string) {
+	s.mu.Lock()
+	s.hashes = hashes
+	s.verified = make(map[[sha256.Size]byte]string)
+	s.mu.Unlock()
+}
+
+func (s *userStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.hashes)
+}
+
+// verify checks the password of username, the legacy pair is used only if both are given.
+// bcrypt is slow by design, so successful checks are cached until the next set.
+func (s *userStore) verify(username, password string) bool {
+	if len(config.Username) > 0 && len(config.Password) > 0 && username == config.Username {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) == 1
+	}
+	s.mu.RLock()
+	hash, ok := s.hashes[username]
+	key := sha256.Sum256([]byte(username + ":" + password))
+	cached, isCached := s.verified[key]
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if isCached && cached == hash {
+		return true
+	}
+	if !checkPasswordHash(hash, password) {
+		return false
+	}
+	s.mu.Lock()
+	s.verified[key] = hash
+	s.mu.Unlock()
+	return true
+}
+
+// checkPasswordHash supports bcrypt ($2a$, $2b$, $2y$) and {SHA} hashes
+func checkPasswordHash(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(expected)) == 1
+	}
+	return false
+}
+
+func validatePasswordHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "{SHA}"):
+		_, err := base64.StdEncoding.DecodeString(hash[len("{SHA}"):])
+		return err
+	}
+	return fmt.Errorf("unsupported hash format, please use bcrypt or {SHA}")
+}
+
+// parseHtpasswd parses the "username:hash" lines of a htpasswd file
+func parseHtpasswd(data []byte) (map[string]string, error) {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		s := strings.IndexByte(text, ':')
+		if s <= 0 {
+			return nil, fmt.Errorf("line %d: expected username:hash", line)
+		}
+		username, hash := text[:s], text[s+1:]
+		if err := validatePasswordHash(hash); err != nil {
+			return nil, fmt.Errorf("line %d: user %s: %v", line, username, err)
+		}
+		hashes[username] = hash
+	}
+	return hashes, scanner.Err()
+}
+
+// loadUsers merges config.Users and config.HtpasswdFile into the user store,
+// the htpasswd file wins when a username is in both
+func loadUsers() error {
+	hashes, err := readUsers()
+	if err != nil {
+		return err
+	}
+	users.set(hashes)
+	return nil
+}
+
+// readUsers returns the password hashes of config.Users and config.HtpasswdFile
+func readUsers() (map[string]string, error) {
+	hashes := make(map[string]string)
+	for username, hash := range config.Users {
+		if err := validatePasswordHash(hash); err != nil {
+			return nil, fmt.Errorf("users: %s: %v", username, err)
+		}
+		hashes[username] = hash
+	}
+	if len(config.HtpasswdFile) > 0 {
+		data, err := ioutil.ReadFile(config.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		fileHashes, err := parseHtpasswd(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.HtpasswdFile, err)
+		}
+		for username, hash := range fileHashes {
+			hashes[username] = hash
+		}
+	}
+	return hashes, nil
+}
+
+// watchedHtpasswd is the htpasswd files being watched
+var watchedHtpasswd = make(map[string]bool)
+
+// watchHtpasswd reloads the users when the htpasswd file is changed,
+// the old users are kept if the new file is invalid.
+// It is called again after reloading config, the file is watched once.
+func watchHtpasswd() {
+	file := config.HtpasswdFile
+	if len(file) == 0 || watchedHtpasswd[file] {
+		return
+	}
+	watchedHtpasswd[file] = true
+	watchFile(file, 2*time.Second, func() {
+		configMutex.RLock()
+		defer configMutex.RUnlock()
+		if file != config.HtpasswdFile {
+			return
+		}
+		if err := loadUsers(); err != nil {
+			log.Printf("Reload %s failed, keep the old users: %v\n", file, err)
+			return
+		}
+		log.Printf("Reload %s: %d user(s)\n", file, users.len())
+	})
+}
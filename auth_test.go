@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestUserStoreVerify(t *testing.T) {
+	defer func(username, password string) {
+		config.Username, config.Password = username, password
+	}(config.Username, config.Password)
+	// {SHA} of "secret"
+	s := &userStore{}
+	s.set(map[string]string{
+		"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"admin": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	})
+
+	tests := []struct {
+		username, password string
+		legacyUser         string
+		legacyPassword     string
+		want               bool
+	}{
+		{"alice", "secret", "", "", true},
+		{"alice", "wrong", "", "", false},
+		{"bob", "", "", "", false},
+		// the legacy pair without a password is ignored
+		{"admin", "", "admin", "", false},
+		{"admin", "secret", "admin", "", true},
+		{"admin", "pass", "admin", "pass", true},
+		{"admin", "secret", "admin", "pass", false},
+		{"admin", "", "admin", "pass", false},
+		{"alice", "secret", "admin", "pass", true},
+	}
+	for _, tt := range tests {
+		config.Username, config.Password = tt.legacyUser, tt.legacyPassword
+		if got := s.verify(tt.username, tt.password); got != tt.want {
+			t.Errorf("verify(%q, %q) with username %q, password %q = %v, want %v",
+				tt.username, tt.password, tt.legacyUser, tt.legacyPassword, got, tt.want)
+		}
+	}
+}
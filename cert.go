@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// the default files of the self-signed certificate if certfile and keyfile are not given
+const (
+	selfSignedCertFile = "selfsigned.crt"
+	selfSignedKeyFile  = "selfsigned.key"
+)
+
+// selfSignedOrganization marks the certificates generated by the server,
+// only they are regenerated when the hosts are changed or they are expiring
+const selfSignedOrganization = "SimpleHttpServer self-signed"
+
+// prepareSelfSigned generates the self-signed certificate to config.CertFile and config.KeyFile,
+// an existing certificate is kept unless it is generated by the server and is expiring
+// or does not cover the configured hosts, so its fingerprint does not change with the network
+func prepareSelfSigned() error {
+	if !config.SelfSigned {
+		return nil
+	}
+	if len(config.CertFile) == 0 && len(config.KeyFile) == 0 {
+		config.CertFile, config.KeyFile = selfSignedCertFile, selfSignedKeyFile
+	} else if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return errors.New("certfile and keyfile should be both given or both empty for selfsigned")
+	}
+	if pair, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return err
+		}
+		if !isSelfSignedByServer(cert) || coversHosts(cert, requiredSelfSignedHosts()) {
+			return nil
+		}
+	} else if fileOrDirIsExist(config.CertFile) || fileOrDirIsExist(config.KeyFile) {
+		return err
+	}
+	hosts := selfSignedHosts()
+	log.Printf("Generate self-signed certificate for %s\n", strings.Join(hosts, ", "))
+	return generateSelfSigned(config.CertFile, config.KeyFile, hosts)
+}
+
+// requiredSelfSignedHosts returns the hosts which the self-signed certificate must cover:
+// localhost, the loopback IPs, config.SelfSignedHosts, and the IPs of local interfaces
+// except the link-local ones if config.SelfSignedIPs is true
+func requiredSelfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if config.SelfSignedIPs {
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() &&
+					!ipnet.IP.IsLinkLocalUnicast() {
+					hosts = append(hosts, ipnet.IP.String())
+				}
+			}
+		}
+	}
+	return uniqueHosts(append(hosts, config.SelfSignedHosts...))
+}
+
+// selfSignedHosts returns the hosts of a new self-signed certificate,
+// they are the required hosts and the host name
+func selfSignedHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	return uniqueHosts(append(hosts, requiredSelfSignedHosts()...))
+}
+
+func uniqueHosts(hosts []string) []string {
+	seen := make(map[string]bool)
+	unique := hosts[:0]
+	for _, h := range hosts {
+		if !seen[h] {
+			seen[h] = true
+			unique = append(unique, h)
+		}
+	}
+	return unique
+}
+
+func isSelfSignedByServer(cert *x509.Certificate) bool {
+	o := cert.Subject.Organization
+	return len(o) == 1 && o[0] == selfSignedOrganization
+}
+
+// coversHosts reports whether cert has all hosts and is valid for 30 days at least,
+// the old certificates generated as a CA are not covering, the browsers reject them
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	if cert.IsCA || time.Now().Add(30*24*time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			found := false
+			for _, v := range cert.IPAddresses {
+				if v.Equal(ip) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		} else if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSelfSigned writes an ECDSA P-256 certificate valid for one year,
+// it is an end-entity certificate rather than a CA, which Firefox rejects as a server certificate
+func generateSelfSigned(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{selfSignedOrganization},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the DER certificate like "AB:CD:..."
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// printCertificate prints the hosts, expiry and fingerprint of the certificate
+func printCertificate(cert *x509.Certificate) {
+	hosts := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	log.Printf("Certificate for %s, expires %s\n", strings.Join(hosts, ", "), cert.NotAfter.Format("2006-01-02"))
+	log.Println("Certificate SHA-256 fingerprint:", certFingerprint(cert.Raw))
+}
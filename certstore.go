@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme"
+)
+
+// CertificatePair is a certificate and its key for the TLS listener,
+// it is chosen by the host names of the certificate
+type CertificatePair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// certStore holds the certificates of the TLS listener, they are replaced without restarting the server
+type certStore struct {
+	mu     sync.RWMutex
+	certs  []*tls.Certificate
+	byName map[string]*tls.Certificate
+}
+
+var certs = &certStore{}
+
+// set replaces all certificates, the first one is the default for the unknown host names
+func (s *certStore) set(certs []*tls.Certificate) {
+	byName := make(map[string]*tls.Certificate)
+	for _, cert := range certs {
+		for _, name := range cert.Leaf.DNSNames {
+			name = strings.ToLower(name)
+			if _, ok := byName[name]; !ok {
+				byName[name] = cert
+			}
+		}
+	}
+	s.mu.Lock()
+	s.certs = certs
+	s.byName = byName
+	s.mu.Unlock()
+}
+
+// getCertificate chooses the certificate by SNI, the exact name first, then the wildcard name
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.certs) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.certs[0], nil
+}
+
+func (s *certStore) print() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, cert := range s.certs {
+		printCertificate(cert.Leaf)
+	}
+}
+
+// certificatePairs returns config.CertFile and config.KeyFile followed by config.Certificates
+func certificatePairs() []CertificatePair {
+	var pairs []CertificatePair
+	if len(config.CertFile) > 0 || len(config.KeyFile) > 0 {
+		pairs = append(pairs, CertificatePair{CertFile: config.CertFile, KeyFile: config.KeyFile})
+	}
+	return append(pairs, config.Certificates...)
+}
+
+// readCertificates loads the certificate pairs of config
+func readCertificates() ([]*tls.Certificate, error) {
+	pairs := certificatePairs()
+	if len(pairs) == 0 && len(config.ACMEDomains) == 0 {
+		return nil, errors.New("certfile and keyfile, certificates or acmedomains should be given for addrtls")
+	}
+	var list []*tls.Certificate
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pair.CertFile, err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("%s: %v", pair.CertFile, err)
+		}
+		list = append(list, &cert)
+	}
+	return list, nil
+}
+
+// watchedCertificates is the certificate and key files being watched
+var watchedCertificates = make(map[string]bool)
+
+// watchCertificates reloads the certificates when their files are changed,
+// the old certificates are kept if any of the new files is invalid, e.g. the key is not renewed yet.
+// It is called again after reloading config, each file is watched once.
+func watchCertificates() {
+	if len(config.AddrTLS) == 0 {
+		return
+	}
+	for _, pair := range certificatePairs() {
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			if watchedCertificates[file] {
+				continue
+			}
+			watchedCertificates[file] = true
+			watchFile(file, 2*time.Second, func() {
+				configMutex.RLock()
+				defer configMutex.RUnlock()
+				list, err := readCertificates()
+				if err != nil {
+					log.Printf("Reload certificates failed, keep the old ones: %v\n", err)
+					return
+				}
+				certs.set(list)
+				log.Println("Reload certificates")
+				certs.print()
+			})
+		}
+	}
+}
+
+// listenAndServeTLS serves TLS with the ACME certificates and the certificates of certs
+func listenAndServeTLS(server *fasthttp.Server, addr string) error {
+	tlsConfig := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok, err := getACMECertificate(hello); ok {
+				return cert, err
+			}
+			return certs.getCertificate(hello)
+		},
+		PreferServerCipherSuites: true,
+	}
+	if acmeManager != nil {
+		tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	}
+	if err := configClientAuth(tlsConfig); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(tls.NewListener(ln, tlsConfig))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// checkConfig loads the config file strictly and returns all the errors in it,
+// the unknown keys are reported with their line numbers by the YAML parser.
+// After the strict error, the config is loaded leniently to check the other options.
+func checkConfig() []error {
+	var errs []error
+	cfg, err := loadConfig(true)
+	if err != nil {
+		errs = append(errs, err)
+		// the warnings of lenient loading are the errors above
+		log.SetOutput(ioutil.Discard)
+		cfg, err = loadConfig(false)
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			// it is the strict error again, e.g. a type error, check the options decoded around it
+			cfg = decodePartialConfig()
+		}
+	}
+	config = cfg
+	errs = append(errs, validateConfig(cfg)...)
+	addError := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// the self-signed certificate is generated when the server starts
+	generated := config.SelfSigned && (len(config.CertFile) == 0 || !fileOrDirIsExist(config.CertFile))
+	if len(config.AddrTLS) > 0 && !generated {
+		if _, err := readCertificates(); err != nil {
+			addError("certificates: %v", err)
+		}
+	}
+	if _, err := readUsers(); err != nil {
+		addError("users: %v", err)
+	}
+	if _, _, err := loadTemplates(); err != nil {
+		addError("templatedir: %v", err)
+	}
+	return errs
+}
+
+// decodePartialConfig decodes the config file ignoring the errors, the YAML parser decodes
+// the other options around the type errors
+func decodePartialConfig() *Config {
+	cfg := defaultConfig()
+	if data, err := ioutil.ReadFile(*configFile); err == nil {
+		yaml.Unmarshal(data, cfg)
+	}
+	if cfg.Paths == nil {
+		cfg.Paths = make(map[string]*Mount)
+	}
+	return cfg
+}
+
+// validateConfig returns the errors of the addresses, durations, sizes, paths and access rules of cfg,
+// the files of users, certificates and templates are not loaded
+func validateConfig(cfg *Config) []error {
+	var errs []error
+	addError := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	for name, addr := range map[string]string{"addr": cfg.Addr, "addrtls": cfg.AddrTLS} {
+		if len(addr) == 0 {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addError("%s: %v", name, err)
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"readtimeout":     cfg.ReadTimeout,
+		"writetimeout":    cfg.WriteTimeout,
+		"shutdowntimeout": cfg.ShutdownTimeout,
+		"tusexpiry":       cfg.TusExpiry,
+	} {
+		if d < 0 {
+			addError("%s should not be negative", name)
+		}
+	}
+	if cfg.ListingPageSize < 0 {
+		addError("listingpagesize should not be negative")
+	}
+	if cfg.TusMaxSize < 0 {
+		addError("tusmaxsize should not be negative")
+	}
+
+	paths := make([]string, 0, len(cfg.Paths))
+	for k := range cfg.Paths {
+		paths = append(paths, k)
+	}
+	sort.Strings(paths)
+	seen := make(map[string]string)
+	for _, k := range paths {
+		m := cfg.Paths[k]
+		if !strings.HasPrefix(k, "/") {
+			addError("paths: %s: URI path should start with '/'", k)
+		}
+		if dup, ok := seen[routePrefix(k)]; ok {
+			addError("paths: %s: duplicate of %s", k, dup)
+		}
+		seen[routePrefix(k)] = k
+		if m == nil || len(m.Root) == 0 {
+			addError("paths: %s: root should not be empty", k)
+			continue
+		}
+		if fi, err := os.Stat(m.Root); err != nil {
+			addError("paths: %s: %v", k, err)
+		} else if !fi.IsDir() {
+			addError("paths: %s: %s is not a directory", k, m.Root)
+		}
+		if err := checkHidePatterns(m.Hide); err != nil {
+			addError("paths: %s: %v", k, err)
+		}
+		if err := checkSymlinkPolicy(m.Symlinks); err != nil {
+			addError("paths: %s: %v", k, err)
+		}
+		if m.MaxRequestBodySize < 0 {
+			addError("paths: %s: maxrequestbodysize should not be negative", k)
+		}
+	}
+
+	prefixes := make([]string, 0, len(cfg.Access))
+	for k := range cfg.Access {
+		prefixes = append(prefixes, k)
+	}
+	sort.Strings(prefixes)
+	for _, k := range prefixes {
+		if !strings.HasPrefix(k, "/") {
+			addError("access: %s: URI path should start with '/'", k)
+		}
+		rule := cfg.Access[k]
+		if rule == nil {
+			continue
+		}
+		for _, list := range [][]string{rule.Read, rule.Upload, rule.Manage} {
+			for _, v := range list {
+				if strings.HasPrefix(v, "@") {
+					if _, ok := cfg.Groups[v[1:]]; !ok {
+						addError("access: %s: group %s is not defined", k, v[1:])
+					}
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigDuplicatePaths(t *testing.T) {
+	root, err := ioutil.TempDir("", "simplehttpserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		paths []string
+		errs  []string
+	}{
+		{[]string{"/", "/docs"}, nil},
+		{[]string{"/docs", "/docs/"}, []string{"paths: /docs/: duplicate of /docs"}},
+		{[]string{"/docs/", "/docs//"}, []string{"paths: /docs//: duplicate of /docs/"}},
+		{[]string{"/docs", "/docsx/"}, nil},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.Paths = map[string]*Mount{}
+		for _, k := range tt.paths {
+			cfg.Paths[k] = &Mount{Root: root}
+		}
+		var errs []string
+		for _, err := range validateConfig(cfg) {
+			errs = append(errs, err.Error())
+		}
+		if strings.Join(errs, "\n") != strings.Join(tt.errs, "\n") {
+			t.Errorf("validateConfig(%v) = %q, want %q", tt.paths, errs, tt.errs)
+		}
+	}
+}
+
+func TestMapPathsDuplicatePaths(t *testing.T) {
+	defer func(paths map[string]*Mount) { config.Paths = paths }(config.Paths)
+
+	// the kept one does not depend on the map order
+	for i := 0; i < 10; i++ {
+		config.Paths = map[string]*Mount{
+			"/docs/": {Root: "docs-slash"},
+			"/docs":  {Root: "docs"},
+		}
+		r := mapPaths()
+		if len(r.routes) != 1 {
+			t.Fatalf("len(routes) = %d, want 1", len(r.routes))
+		}
+		if root := r.routes[0].mount.Root; root != "docs" {
+			t.Errorf("root of /docs = %q, want %q", root, "docs")
+		}
+		if _, ok := config.Paths["/docs/"]; ok {
+			t.Error("the duplicate /docs/ is not deleted")
+		}
+	}
+}
+
+func TestCheckConfigTypeError(t *testing.T) {
+	defer func(cfg *Config, file string) { config, *configFile = cfg, file }(config, *configFile)
+	dir, err := ioutil.TempDir("", "simplehttpserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*configFile = filepath.Join(dir, "config.yaml")
+	data := "readtimeout: 5x\npaths:\n  /docs:\n    root: " + filepath.Join(dir, "missing") + "\n"
+	if err := ioutil.WriteFile(*configFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the type error is reported once, and the paths decoded around it are checked
+	errs := checkConfig()
+	if len(errs) != 2 {
+		t.Fatalf("checkConfig() = %q, want 2 errors", errs)
+	}
+	if msg := errs[0].Error(); !strings.Contains(msg, "5x") {
+		t.Errorf("errs[0] = %q, want the type error of readtimeout", msg)
+	}
+	if msg := errs[1].Error(); !strings.HasPrefix(msg, "paths: /docs: ") {
+		t.Errorf("errs[1] = %q, want the error of /docs", msg)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// the client certificate modes of the TLS listener
+const (
+	// clientAuthOptional verifies the client certificate if it is given
+	clientAuthOptional = "optional"
+	// clientAuthRequire requires a valid client certificate
+	clientAuthRequire = "require"
+)
+
+func checkClientAuth(cfg *Config) error {
+	switch cfg.ClientAuth {
+	case "":
+		// the users of certificates need the verified certificates
+		if len(cfg.ClientCertUsers) > 0 {
+			return errors.New("clientauth should be given for clientcertusers")
+		}
+		return nil
+	case clientAuthOptional, clientAuthRequire:
+	default:
+		return errors.New("clientauth should be optional or require")
+	}
+	if len(cfg.ClientCAFile) == 0 {
+		return errors.New("clientcafile should be given for clientauth")
+	}
+	_, err := loadCertPool(cfg.ClientCAFile)
+	return err
+}
+
+// configClientAuth sets the client certificate verification of tlsConfig by config.ClientAuth,
+// the ACME TLS-ALPN-01 challenges do not need a client certificate
+func configClientAuth(tlsConfig *tls.Config) error {
+	if len(config.ClientAuth) == 0 {
+		return nil
+	}
+	pool, err := loadCertPool(config.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientCAs = pool
+	if config.ClientAuth != clientAuthRequire {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		return nil
+	}
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	if acmeManager != nil {
+		challengeConfig := tlsConfig.Clone()
+		challengeConfig.ClientAuth = tls.NoClientCert
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if isACMEChallengeHello(hello) {
+				return challengeConfig, nil
+			}
+			return nil, nil
+		}
+	}
+	return nil
+}
+
+// certIdentities returns the identities of client certificate in the order to map them to users,
+// e.g. "CN=backup", "DNS:ci.example.lan", "EMAIL:bot@example.org", "URI:spiffe://example.org/bot", "IP:10.0.0.2"
+func certIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if len(cert.Subject.CommonName) > 0 {
+		ids = append(ids, "CN="+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		ids = append(ids, "EMAIL:"+email)
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, "URI:"+uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		ids = append(ids, "IP:"+ip.String())
+	}
+	return ids
+}
+
+// clientCertUser returns the user of the verified client certificate by config.ClientCertUsers,
+// ok is false if there is no certificate or it is not mapped to a user
+func clientCertUser(ctx *fasthttp.RequestCtx) (user string, ok bool) {
+	if len(config.ClientCertUsers) == 0 {
+		return "", false
+	}
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return "", false
+	}
+	for _, id := range certIdentities(state.VerifiedChains[0][0]) {
+		if user, ok := config.ClientCertUsers[id]; ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func printClientAuth() {
+	if len(config.AddrTLS) == 0 || len(config.ClientAuth) == 0 {
+		return
+	}
+	log.Println("ClientAuth:", config.ClientAuth)
+	log.Println("ClientCAFile:", config.ClientCAFile)
+	if n := len(config.ClientCertUsers); n > 0 {
+		log.Printf("Have %d client certificate user(s)\n", n)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of the environment variables of config,
+// e.g. SIMPLEHTTPSERVER_ADDR for addr and SIMPLEHTTPSERVER_HTTP_PROXY for HTTP_PROXY
+const envPrefix = "SIMPLEHTTPSERVER_"
+
+// envName returns the environment variable of Config field, named by its YAML key
+func envName(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if len(key) == 0 {
+		key = field.Name
+	}
+	return envPrefix + strings.ToUpper(key)
+}
+
+// applyEnv overwrites cfg by the environment variables.
+// The strings are used as is, the lists can be written as "a,b" or in YAML flow style "[a, b]",
+// others are YAML values, e.g. "{/: ., /d: {root: /data, webdav: true}}" for the paths.
+// The unknown variables with envPrefix are errors in strict mode and warnings otherwise.
+func applyEnv(cfg *Config, strict bool) error {
+	v := reflect.ValueOf(cfg).Elem()
+	known := make(map[string]bool)
+	for i := 0; i < v.NumField(); i++ {
+		name := envName(v.Type().Field(i))
+		known[name] = true
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, envPrefix) && !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	err := fmt.Errorf("unknown environment variables %s", strings.Join(unknown, ", "))
+	if strict {
+		return err
+	}
+	log.Printf("warning: %v\n", err)
+	return nil
+}
+
+func setEnvField(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String &&
+		!strings.HasPrefix(strings.TrimSpace(value), "["):
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	}
+	// the value replaces the whole map or list
+	p := reflect.New(field.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), p.Interface()); err != nil {
+		return err
+	}
+	field.Set(p.Elem())
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+
+	"github.com/valyala/fasthttp"
+)
+
+// fileOpsPath is the URI path of the file management API
+const fileOpsPath = "/fileops"
+
+// fileOp is the request of the file management API, e.g.:
+//
+//	{"op":"mkdir","path":"/docs/new"}
+//	{"op":"rename","path":"/docs/a.txt","name":"b.txt"}
+//	{"op":"move","path":"/docs/a.txt","to":"/docs/sub"}
+//	{"op":"delete","path":"/docs/a.txt"}
+//
+// The paths are URI paths, "to" is the destination directory in the same mapped path.
+type fileOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Name string `json:"name,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// fileOpError is an error with the response status code
+type fileOpError struct {
+	statusCode int
+	message    string
+}
+
+func (e *fileOpError) Error() string {
+	return e.message
+}
+
+func newFileOpError(statusCode int, message string) error {
+	return &fileOpError{statusCode: statusCode, message: message}
+}
+
+// fileOpsHandler serves the file management API, it needs basic authorization.
+// The request must be JSON, so the cross-site forms can not post it without CORS.
+func fileOpsHandler(ctx *fasthttp.RequestCtx, user string) {
+	if !enableBasicAuth {
+		writeJSON(ctx, fasthttp.StatusForbidden, map[string]string{"error": "File operations need basic authorization"})
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(string(ctx.Request.Header.ContentType())); err != nil || mediaType != "application/json" {
+		writeJSON(ctx, fasthttp.StatusUnsupportedMediaType, map[string]string{"error": "Content-Type should be application/json"})
+		return
+	}
+	op := &fileOp{}
+	if err := json.Unmarshal(ctx.PostBody(), op); err != nil {
+		writeJSON(ctx, fasthttp.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := doFileOp(op, user); err != nil {
+		if e, ok := err.(*fileOpError); ok {
+			writeJSON(ctx, e.statusCode, map[string]string{"error": e.message})
+			return
+		}
+		// do not show the local paths in the errors of os package
+		statusCode := fasthttp.StatusInternalServerError
+		logInfo(statusCode, "File operation %s %s failed: %s", op.Op, op.Path, err.Error())
+		writeJSON(ctx, statusCode, map[string]string{"error": fasthttp.StatusMessage(statusCode)})
+		return
+	}
+	logInfo(0, "%s | %s | File operation %s %s %s%s", ctx.RemoteIP(), user, op.Op, op.Path, op.Name, op.To)
+	writeJSON(ctx, fasthttp.StatusOK, map[string]string{"message": "ok"})
+}
+
+// fileOpsConfigured reports whether the file operations are enabled globally or for any path
+func fileOpsConfigured() bool {
+	if config.EnableFileOps {
+		return true
+	}
+	for _, m := range config.Paths {
+		if m != nil && m.EnableFileOps != nil && *m.EnableFileOps {
+			return true
+		}
+	}
+	return false
+}
+
+func doFileOp(op *fileOp, user string) error {
+	rt, local, err := resolveFileOpPath(op.Path, user)
+	if err != nil {
+		return err
+	}
+	if local == filepath.Clean(rt.mount.Root) {
+		return newFileOpError(fasthttp.StatusBadRequest, "Can not change the root of mapped path")
+	}
+	// delete, rename and move change the directory tree, the rules below it are also checked
+	if path := pathpkg.Clean("/" + op.Path); op.Op != "mkdir" &&
+		(!isTreeAllowed(path, user, accessRead) || !isTreeAllowed(path, user, accessManage)) {
+		return newFileOpError(fasthttp.StatusForbidden, "Access denied to the files below "+path)
+	}
+
+	switch op.Op {
+	case "mkdir":
+		if err := validateFilename(filepath.Base(local)); err != nil {
+			return newFileOpError(fasthttp.StatusBadRequest, err.Error())
+		}
+		if fileOrDirIsExist(local) {
+			return newFileOpError(fasthttp.StatusConflict, "The path exists")
+		}
+		return os.Mkdir(local, 0755)
+	case "delete":
+		if !fileOrDirIsExist(local) {
+			return newFileOpError(fasthttp.StatusNotFound, "The path does not exist")
+		}
+		return os.RemoveAll(local)
+	case "rename":
+		if err := validateFilename(op.Name); err != nil {
+			return newFileOpError(fasthttp.StatusBadRequest, err.Error())
+		}
+		if rt.mount.isHidden(pathpkg.Join(rt.rel(pathpkg.Clean("/"+op.Path)), "..", op.Name)) {
+			return newFileOpError(fasthttp.StatusForbidden, errHidden.Error())
+		}
+		return renamePath(local, filepath.Join(filepath.Dir(local), op.Name))
+	case "move":
+		toRoute, toDir, err := resolveFileOpPath(op.To, user)
+		if err != nil {
+			return err
+		}
+		if toRoute != rt {
+			return newFileOpError(fasthttp.StatusBadRequest, "Can not move to other mapped path")
+		}
+		if !dirIsExist(toDir) {
+			return newFileOpError(fasthttp.StatusConflict, "The destination directory does not exist")
+		}
+		if isWithin(local, toDir) {
+			return newFileOpError(fasthttp.StatusBadRequest, "Can not move a directory into itself")
+		}
+		return renamePath(local, filepath.Join(toDir, filepath.Base(local)))
+	}
+	return newFileOpError(fasthttp.StatusBadRequest, "Unknown op "+op.Op)
+}
+
+// resolveFileOpPath checks the manage access and returns the route and local path of URI path
+func resolveFileOpPath(path, user string) (*route, string, error) {
+	path = pathpkg.Clean("/" + path)
+	if !isAllowed(path, user, accessManage) {
+		return nil, "", newFileOpError(fasthttp.StatusForbidden, "Access denied to "+path)
+	}
+	rt := routes.match(path)
+	if rt == nil || !rt.mount.fileOpsEnabled() {
+		return nil, "", newFileOpError(fasthttp.StatusForbidden, "File operations are not enabled for "+path)
+	}
+	local, err := resolveLocalPath(rt, path)
+	if err != nil {
+		return nil, "", newFileOpError(fasthttp.StatusForbidden, err.Error())
+	}
+	return rt, local, nil
+}
+
+func renamePath(src, dst string) error {
+	if !fileOrDirIsExist(src) {
+		return newFileOpError(fasthttp.StatusNotFound, "The path does not exist")
+	}
+	if fileOrDirIsExist(dst) {
+		return newFileOpError(fasthttp.StatusConflict, "The destination exists")
+	}
+	return os.Rename(src, dst)
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, statusCode int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf8")
+	ctx.SetBody(data)
+}
@@ -4,6 +4,8 @@ go 1.13
 
 require (
 	github.com/fatih/color v1.9.0
-	github.com/valyala/fasthttp v1.7.1
+	github.com/valyala/fasthttp v1.34.0
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	golang.org/x/net v0.0.0-20220225172249-27dd8689420f
 	gopkg.in/yaml.v2 v2.2.7
 )
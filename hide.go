@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+var errHidden = errors.New("path is hidden")
+
+// isHidden reports whether the path relative to the mount root is hidden,
+// a path is hidden if any element of it is a dotfile or matches a hide pattern.
+// The patterns without "/" match the names, e.g. "*.bak",
+// others match the paths relative to the mount root, e.g. "/build/tmp".
+func (m *Mount) isHidden(rel string) bool {
+	showDotfiles := config.ShowDotfiles
+	if m.ShowDotfiles != nil {
+		showDotfiles = *m.ShowDotfiles
+	}
+	rel = strings.Trim(pathpkg.Clean("/"+rel), "/")
+	if len(rel) == 0 {
+		return false
+	}
+	names := strings.Split(rel, "/")
+	for i, name := range names {
+		if !showDotfiles && strings.HasPrefix(name, ".") {
+			return true
+		}
+		if matchHidePatterns(config.Hide, name, names[:i+1]) ||
+			matchHidePatterns(m.Hide, name, names[:i+1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHidePatterns(patterns []string, name string, names []string) bool {
+	for _, pattern := range patterns {
+		var matched bool
+		if strings.Contains(pattern, "/") {
+			matched, _ = pathpkg.Match(strings.Trim(pattern, "/"), strings.Join(names, "/"))
+		} else {
+			matched, _ = pathpkg.Match(pattern, name)
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isHiddenPath reports whether URI path is hidden in its mapped path
+func isHiddenPath(path string) bool {
+	rt := routes.match(path)
+	return rt != nil && rt.mount.isHidden(rt.rel(path))
+}
+
+// checkHidePatterns returns the error of the first bad pattern
+func checkHidePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := pathpkg.Match(strings.Trim(pattern, "/"), ""); err != nil {
+			return errors.New("bad hide pattern " + pattern)
+		}
+	}
+	return nil
+}
+
+// filterHidden removes the hidden files in the directory of rel
+func filterHidden(m *Mount, rel string, ff []os.FileInfo) []os.FileInfo {
+	visible := ff[:0]
+	for _, f := range ff {
+		if !m.isHidden(pathpkg.Join(rel, f.Name())) {
+			visible = append(visible, f)
+		}
+	}
+	return visible
+}
+
+func printHide() {
+	log.Println("ShowDotfiles:", config.ShowDotfiles)
+	if len(config.Hide) > 0 {
+		log.Println("Hide:", config.Hide)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// dirListing is the JSON directory listing
+type dirListing struct {
+	Path  string     `json:"path"`
+	Items []dirEntry `json:"items"`
+	// Total is the count of filtered items in all pages
+	Total   int    `json:"total"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"perpage"`
+	Sort    string `json:"sort"`
+	Order   string `json:"order"`
+	Filter  string `json:"filter,omitempty"`
+}
+
+// dirEntry is an item of the directory listing
+type dirEntry struct {
+	Name string `json:"name"`
+	// Type is "dir" or "file"
+	Type  string    `json:"type"`
+	Mode  string    `json:"mode"`
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+	// Link is the escaped URI path
+	Link string `json:"link"`
+}
+
+func newDirEntry(link string, f os.FileInfo) dirEntry {
+	entry := dirEntry{
+		Name:  f.Name(),
+		Type:  "file",
+		Mode:  f.Mode().String(),
+		Size:  f.Size(),
+		MTime: f.ModTime(),
+		Link:  (&url.URL{Path: link}).EscapedPath(),
+	}
+	if f.IsDir() {
+		entry.Type = "dir"
+		entry.Size = 0
+	}
+	return entry
+}
+
+// newDirListing returns the listing of files in the directory of URI path
+func newDirListing(path string, ff []os.FileInfo) *dirListing {
+	listing := &dirListing{Path: path, Items: make([]dirEntry, 0, len(ff)), Total: len(ff)}
+	if path == "/" {
+		path = ""
+	}
+	for _, f := range ff {
+		listing.Items = append(listing.Items, newDirEntry(path+"/"+f.Name(), f))
+	}
+	return listing
+}
+
+// wantsJSON reports whether the client asks for a JSON response
+// by the query format=json or the header Accept: application/json
+func wantsJSON(ctx *fasthttp.RequestCtx) bool {
+	if format := ctx.QueryArgs().Peek("format"); len(format) > 0 {
+		return string(format) == "json"
+	}
+	return bytes.Contains(ctx.Request.Header.Peek("Accept"), []byte("application/json"))
+}
+
+// listingOptions is parsed from the query, e.g.:
+//
+//	?sort=mtime&order=desc&filter=*.log&page=2&perpage=100
+type listingOptions struct {
+	// Sort is "name", "size", "mtime" or "type"
+	Sort string
+	// Order is "asc" or "desc"
+	Order string
+	// Filter is a glob pattern if it has any of "*?[", otherwise a substring of name,
+	// both are case insensitive
+	Filter  string
+	Page    int
+	PerPage int
+}
+
+func parseListingOptions(ctx *fasthttp.RequestCtx) (*listingOptions, error) {
+	args := ctx.QueryArgs()
+	o := &listingOptions{
+		Sort:    string(args.Peek("sort")),
+		Order:   string(args.Peek("order")),
+		Filter:  string(args.Peek("filter")),
+		Page:    1,
+		PerPage: config.ListingPageSize,
+	}
+	switch o.Sort {
+	case "":
+		o.Sort = "name"
+	case "name", "size", "mtime", "type":
+	default:
+		return nil, errors.New("sort should be name, size, mtime or type")
+	}
+	switch o.Order {
+	case "":
+		o.Order = "asc"
+	case "asc", "desc":
+	default:
+		return nil, errors.New("order should be asc or desc")
+	}
+	if strings.ContainsAny(o.Filter, "*?[") {
+		if _, err := pathpkg.Match(strings.ToLower(o.Filter), ""); err != nil {
+			return nil, errors.New("filter is a bad pattern")
+		}
+	}
+	if v := args.Peek("page"); len(v) > 0 {
+		i, err := strconv.Atoi(string(v))
+		if err != nil || i < 1 {
+			return nil, errors.New("page should be a number large than 0")
+		}
+		o.Page = i
+	}
+	if v := args.Peek("perpage"); len(v) > 0 {
+		i, err := strconv.Atoi(string(v))
+		if err != nil || i < 0 {
+			return nil, errors.New("perpage should be a number large or equal 0")
+		}
+		o.PerPage = i
+	}
+	return o, nil
+}
+
+func (o *listingOptions) match(name string) bool {
+	if len(o.Filter) == 0 {
+		return true
+	}
+	filter, name := strings.ToLower(o.Filter), strings.ToLower(name)
+	if strings.ContainsAny(filter, "*?[") {
+		matched, _ := pathpkg.Match(filter, name)
+		return matched
+	}
+	return strings.Contains(name, filter)
+}
+
+func (o *listingOptions) less(a, b *dirEntry) bool {
+	switch o.Sort {
+	case "size":
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case "mtime":
+		if !a.MTime.Equal(b.MTime) {
+			return a.MTime.Before(b.MTime)
+		}
+	case "type":
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+	}
+	return a.Name < b.Name
+}
+
+// apply filters, sorts and paginates the items, perpage 0 is all items in one page
+func (l *dirListing) apply(o *listingOptions) {
+	items := l.Items[:0]
+	for _, entry := range l.Items {
+		if o.match(entry.Name) {
+			items = append(items, entry)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if o.Order == "desc" {
+			return o.less(&items[j], &items[i])
+		}
+		return o.less(&items[i], &items[j])
+	})
+	l.Total = len(items)
+	l.Sort, l.Order, l.Filter = o.Sort, o.Order, o.Filter
+	l.Page, l.PerPage = o.Page, o.PerPage
+	if o.PerPage > 0 {
+		start := len(items)
+		if o.Page <= l.pages() {
+			start = (o.Page - 1) * o.PerPage
+		}
+		end := len(items)
+		if o.PerPage < end-start {
+			end = start + o.PerPage
+		}
+		items = items[start:end]
+	}
+	l.Items = items
+}
+
+// pages returns the count of pages
+func (l *dirListing) pages() int {
+	if l.PerPage <= 0 || l.Total == 0 {
+		return 1
+	}
+	return (l.Total + l.PerPage - 1) / l.PerPage
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// testFileInfo is an os.FileInfo without a file
+type testFileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (f *testFileInfo) Name() string       { return f.name }
+func (f *testFileInfo) Size() int64        { return f.size }
+func (f *testFileInfo) Mode() os.FileMode  { return f.mode }
+func (f *testFileInfo) ModTime() time.Time { return f.mtime }
+func (f *testFileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f *testFileInfo) Sys() interface{}   { return nil }
+
+func jsonKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDirListingJSON(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	listing := newDirListing("/docs", []os.FileInfo{
+		&testFileInfo{name: "a b.txt", size: 10, mode: 0644, mtime: mtime},
+		&testFileInfo{name: "sub", size: 4096, mode: os.ModeDir | 0755, mtime: mtime},
+	})
+	data, err := json.Marshal(listing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	var top map[string]interface{}
+	if err := json.Unmarshal(data, &top); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	wantTop := []string{"items", "order", "page", "path", "perpage", "sort", "total"}
+	if keys := jsonKeys(top); !reflect.DeepEqual(keys, wantTop) {
+		t.Errorf("listing keys = %v, want %v", keys, wantTop)
+	}
+	if len(v.Items) != 2 {
+		t.Fatalf("len(items) = %d, want 2", len(v.Items))
+	}
+	wantItem := []string{"link", "mode", "mtime", "name", "size", "type"}
+	for _, item := range v.Items {
+		if keys := jsonKeys(item); !reflect.DeepEqual(keys, wantItem) {
+			t.Errorf("item keys = %v, want %v", keys, wantItem)
+		}
+	}
+
+	file, dir := v.Items[0], v.Items[1]
+	if file["name"] != "a b.txt" || file["type"] != "file" || file["size"] != float64(10) ||
+		file["link"] != "/docs/a%20b.txt" || file["mode"] != "-rw-r--r--" ||
+		file["mtime"] != "2020-01-02T03:04:05Z" {
+		t.Errorf("file item = %v", file)
+	}
+	if dir["name"] != "sub" || dir["type"] != "dir" || dir["size"] != float64(0) || dir["link"] != "/docs/sub" {
+		t.Errorf("dir item = %v", dir)
+	}
+}
+
+func TestDirListingRootLink(t *testing.T) {
+	listing := newDirListing("/", []os.FileInfo{&testFileInfo{name: "a.txt"}})
+	if link := listing.Items[0].Link; link != "/a.txt" {
+		t.Errorf("link = %q, want %q", link, "/a.txt")
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		uri    string
+		accept string
+		want   bool
+	}{
+		{"/docs", "", false},
+		{"/docs", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"/docs", "application/json", true},
+		{"/docs", "application/json; charset=utf-8", true},
+		{"/docs?format=json", "", true},
+		{"/docs?format=json", "text/html", true},
+		// the query takes precedence over the header
+		{"/docs?format=html", "application/json", false},
+		{"/docs?format=xml", "", false},
+	}
+	for _, tt := range tests {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(tt.uri)
+		if len(tt.accept) > 0 {
+			ctx.Request.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsJSON(ctx); got != tt.want {
+			t.Errorf("wantsJSON(%q, Accept: %q) = %v, want %v", tt.uri, tt.accept, got, tt.want)
+		}
+	}
+}
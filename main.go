@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,9 +39,11 @@ var (
 	addrTLS            = flag.String("addrtls", "", "TCP address to listen to TLS (aka SSL or HTTPS) requests. Leave empty for disabling TLS")
 	certFile           = flag.String("certfile", "", "Path to TLS certificate file")
 	keyFile            = flag.String("keyfile", "", "Path to TLS key file")
+	selfSigned         = flag.String("selfsigned", "", "Generate a self-signed certificate to certfile and keyfile if they do not exist. e.g.: true")
 	compress           = flag.String("compress", "", "Whether to enable transparent response compression. e.g.: true")
 	username           = flag.String("username", "", "Username for basic authentication")
 	password           = flag.String("password", "", "Password for basic authentication")
+	htpasswdFile       = flag.String("htpasswdfile", "", "Path to htpasswd file with bcrypt or {SHA} hashed passwords for basic authentication")
 	path               = flag.String("path", "", "Local path to map to webroot. e.g.: ./")
 	indexNames         = flag.String("indexnames", "", "List of index file names. e.g.: index.html,index.htm")
 	configFile         = flag.String("config", "", "The config file path.")
@@ -52,11 +55,15 @@ var (
 	maxRequestBodySize = flag.String("maxrequestbodysize", "", "Max request body size for upload big file")
 	readTimeout        = flag.String("readtimeout", "", "Limit read timeout, 0s for unlimited")
 	writeTimeout       = flag.String("writetimeout", "", "Limit write timeout, 0s for unlimited")
+	shutdownTimeout    = flag.String("shutdowntimeout", "", "Limit the time to finish active requests on SIGINT or SIGTERM, 0s for unlimited")
 	makeconfig         = flag.String("makeconfig", "", "Make a config file. e.g.: config.yaml")
+	checkconfig        = flag.Bool("checkconfig", false, "Check the config file strictly and exit, the exit status is 1 if it has errors")
 	config             = &Config{}
-	fsMap              = make(map[string]fasthttp.RequestHandler)
+	routes             = &router{}
 	enableBasicAuth    = false
 	logMutex           sync.Mutex
+	// colorOutput is config.EnableColor, it is not changed by reloading config
+	colorOutput bool
 )
 
 // Config from config.yaml
@@ -65,19 +72,49 @@ type Config struct {
 	AddrTLS            string
 	CertFile           string
 	KeyFile            string
+	SelfSigned         bool
+	SelfSignedHosts    []string
+	SelfSignedIPs      bool
+	Certificates       []CertificatePair
+	ACMEDomains        []string
+	ACMEEmail          string
+	ACMEDirectoryURL   string
+	ACMECacheDir       string
+	ACMECAFile         string
+	ClientAuth         string
+	ClientCAFile       string
+	ClientCertUsers    map[string]string
+	RedirectHTTPS      bool
+	HSTS               string
 	Username           string
 	Password           string
+	Users              map[string]string
+	HtpasswdFile       string
+	Groups             map[string][]string
+	Access             map[string]*AccessRule
 	Compress           bool
-	Paths              map[string]string
+	Paths              map[string]*Mount
 	IndexNames         []string
 	Verbose            bool
 	LogFile            string
 	Fallback           string
+	Hide               []string
+	ShowDotfiles       bool
+	Symlinks           string
 	EnableColor        bool
+	TemplateDir        string
+	ListingPageSize    int
 	EnableUpload       bool
+	EnableFileOps      bool
+	EnableTus          bool
+	TusDir             string
+	TusMaxSize         int64
+	TusExpiry          time.Duration
 	MaxRequestBodySize int
 	ReadTimeout        time.Duration
 	WriteTimeout       time.Duration
+	ShutdownTimeout    time.Duration
+	WatchConfig        bool
 	HTTPProxy          string `yaml:"HTTP_PROXY,omitempty"`
 	HTTPSProxy         string `yaml:"HTTPS_PROXY,omitempty"`
 	NoProxy            string `yaml:"NO_PROXY,omitempty"`
@@ -100,134 +137,30 @@ func main() {
 		}
 		return
 	}
-	// load config file
-	fmt.Println(Version)
-	if len(*configFile) > 0 {
-		log.Println("Load config from:", *configFile)
-		data, err := ioutil.ReadFile(*configFile)
-		if err != nil {
-			log.Fatalf("error: %v\n", err)
+	// check config file
+	if *checkconfig {
+		errs := checkConfig()
+		for _, err := range errs {
+			log.Printf("error: %v\n", err)
 		}
-		// parse yaml
-		err = yaml.Unmarshal(data, &config)
-		if err != nil {
-			log.Fatalf("error: %v\n", err)
+		if len(errs) > 0 {
+			os.Exit(1)
 		}
+		log.Println("The config is OK")
+		return
 	}
-	if config.Paths == nil {
-		config.Paths = make(map[string]string)
+	// load config file
+	fmt.Println(Version)
+	cfg, err := loadConfig(false)
+	if err != nil {
+		log.Fatalf("error: %v\n", err)
 	}
+	config = cfg
+	colorOutput = config.EnableColor
 	// set output to logfile
-	if len(*logFile) > 0 {
-		config.LogFile = *logFile
-	}
 	if err := tryEnableLogFile(); err != nil {
 		log.Fatalf("error: %v", err)
 	}
-	// overwrite config
-	if len(*addr) > 0 {
-		config.Addr = *addr
-	}
-	if len(*addrTLS) > 0 {
-		config.AddrTLS = *addrTLS
-	}
-	if len(*certFile) > 0 {
-		config.CertFile = *certFile
-	}
-	if len(*keyFile) > 0 {
-		config.KeyFile = *keyFile
-	}
-	if len(*username) > 0 {
-		config.Username = *username
-	}
-	if len(*password) > 0 {
-		config.Password = *password
-	}
-	if len(config.Username) > 0 && len(config.Password) > 0 {
-		enableBasicAuth = true
-	}
-	switch strings.ToLower(*compress) {
-	case "true":
-		config.Compress = true
-	case "":
-		fallthrough
-	case "false":
-		config.Compress = false
-	default:
-		log.Fatalf("error: %v", fmt.Errorf("argument compress error"))
-	}
-	if len(*path) > 0 {
-		config.Paths["/"] = *path
-	}
-	if len(*indexNames) > 0 {
-		config.IndexNames = strings.Split(*indexNames, ",")
-	}
-	switch strings.ToLower(*verbose) {
-	case "":
-		fallthrough
-	case "true":
-		config.Verbose = true
-	case "false":
-		config.Verbose = false
-	default:
-		log.Fatalf("error: %v", fmt.Errorf("argument verbose error"))
-	}
-	if len(*fallback) > 0 {
-		config.Fallback = *fallback
-	}
-	switch strings.ToLower(*enableColor) {
-	case "":
-		fallthrough
-	case "true":
-		config.EnableColor = true
-	case "false":
-		config.EnableColor = false
-	default:
-		log.Fatalf("error: %v", fmt.Errorf("argument enablecolor error"))
-	}
-	switch strings.ToLower(*enableUpload) {
-	case "":
-		fallthrough
-	case "true":
-		config.EnableUpload = true
-	case "false":
-		config.EnableUpload = false
-	default:
-		log.Fatalf("error: %v", fmt.Errorf("argument enableupload error"))
-	}
-	if len(*maxRequestBodySize) > 0 {
-		i, err := strconv.Atoi(*maxRequestBodySize)
-		if err != nil || i < 0 {
-			log.Fatalf("error: %v", fmt.Errorf("argument maxrequestbodysize error"))
-		}
-		config.MaxRequestBodySize = i
-	}
-	if config.MaxRequestBodySize < 0 {
-		log.Fatalf("error: %v", fmt.Errorf("MaxRequestBodySize must be large or equal 0"))
-	} else if config.MaxRequestBodySize == 0 {
-		config.MaxRequestBodySize = fasthttp.DefaultMaxRequestBodySize
-	}
-	if len(*readTimeout) > 0 {
-		i, err := time.ParseDuration(*readTimeout)
-		if err != nil {
-			log.Fatalf("error: %v", fmt.Errorf("argument readtimeout error"))
-		}
-		config.ReadTimeout = i
-	}
-	if len(*writeTimeout) > 0 {
-		i, err := time.ParseDuration(*writeTimeout)
-		if err != nil {
-			log.Fatalf("error: %v", fmt.Errorf("argument writetimeout error"))
-		}
-		config.WriteTimeout = i
-	}
-
-	// safe warning
-	if len(config.AddrTLS) == 0 || !enableBasicAuth {
-		color.Set(color.FgRed)
-		log.Println("NOT SAFE WARNING: PLEASE TURN ON TLS AND BASIC AUTHORIZATION")
-		color.Unset()
-	}
 
 	// config proxy
 	if len(config.HTTPProxy) > 0 {
@@ -244,54 +177,93 @@ func main() {
 		_ = os.Setenv(NoProxy, config.NoProxy)
 	}
 	printEnv(NoProxy)
+	// map paths, load users, templates and certificates
+	if err := applyConfig(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	if err := setupACME(); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	watchHtpasswd()
+	watchCertificates()
+	go sweepTusUploads()
+
+	// safe warning
+	if len(config.AddrTLS) == 0 || !enableBasicAuth {
+		color.Set(color.FgRed)
+		log.Println("NOT SAFE WARNING: PLEASE TURN ON TLS AND BASIC AUTHORIZATION")
+		color.Unset()
+	}
+	if !enableBasicAuth && fileOpsConfigured() {
+		color.Set(color.FgRed)
+		log.Println("WARNING: FILE OPERATIONS ARE DISABLED WITHOUT BASIC AUTHORIZATION")
+		color.Unset()
+	}
+	if len(config.Addr) > 0 && len(config.AddrTLS) > 0 && enableBasicAuth && !config.RedirectHTTPS {
+		color.Set(color.FgRed)
+		log.Println("NOT SAFE WARNING: THE PASSWORDS CAN BE SENT TO ADDR WITHOUT TLS, PLEASE TURN ON REDIRECTHTTPS")
+		color.Unset()
+	}
 	// run server and output config
 	h := requestHandler
 	if config.Compress {
 		h = fasthttp.CompressHandler(h)
 	}
-	if len(config.Addr) == 0 && len(config.AddrTLS) == 0 {
-		config.Addr = ":8080"
-	}
+	maxBodySize := serverMaxRequestBodySize()
+	var servers []*fasthttp.Server
+	errc := make(chan error, 2)
 	if len(config.Addr) > 0 {
 		log.Println("Server address:", config.Addr)
+		server := newServer(h, maxBodySize)
+		servers = append(servers, server)
 		go func() {
-			server := &fasthttp.Server{
-				Handler:            h,
-				MaxRequestBodySize: config.MaxRequestBodySize,
-				ReadTimeout:        time.Duration(config.ReadTimeout),
-				WriteTimeout:       time.Duration(config.WriteTimeout),
-			}
 			if err := server.ListenAndServe(config.Addr); err != nil {
-				log.Fatalf("error in ListenAndServe: %s", err)
+				errc <- fmt.Errorf("error in ListenAndServe: %s", err)
 			}
 		}()
 	}
 	if len(config.AddrTLS) > 0 {
 		log.Println("Server address TLS:", config.AddrTLS)
-		log.Println("CertFile:", config.CertFile)
-		log.Println("KeyFile:", config.KeyFile)
+		for _, pair := range certificatePairs() {
+			log.Println("CertFile:", pair.CertFile)
+			log.Println("KeyFile:", pair.KeyFile)
+		}
+		certs.print()
+		printACME()
+		printClientAuth()
+		printRedirect()
+		server := newServer(h, maxBodySize)
+		servers = append(servers, server)
 		go func() {
-			server := &fasthttp.Server{
-				Handler:            h,
-				MaxRequestBodySize: config.MaxRequestBodySize,
-				ReadTimeout:        config.ReadTimeout,
-				WriteTimeout:       config.WriteTimeout,
-			}
-			if err := server.ListenAndServeTLS(config.AddrTLS, config.CertFile, config.KeyFile); err != nil {
-				log.Fatalf("error in ListenAndServeTLS: %s", err)
+			if err := listenAndServeTLS(server, config.AddrTLS); err != nil {
+				errc <- fmt.Errorf("error in ListenAndServeTLS: %s", err)
 			}
 		}()
 	}
 	log.Println("BasicAuth:", enableBasicAuth)
+	if len(config.HtpasswdFile) > 0 {
+		log.Println("HtpasswdFile:", config.HtpasswdFile)
+	}
+	if n := users.len(); n > 0 {
+		log.Printf("Have %d hashed password user(s)\n", n)
+	}
+	printAccessRules()
 	log.Println("Compress:", config.Compress)
 	if len(config.Fallback) > 0 {
 		log.Println("Fallback:", config.Fallback)
 	}
 	log.Println("EnableColor:", config.EnableColor)
+	printHide()
+	if len(config.Symlinks) > 0 {
+		log.Println("Symlinks:", config.Symlinks)
+	}
 	log.Println("EnableUpload:", config.EnableUpload)
+	log.Println("EnableFileOps:", config.EnableFileOps)
+	printTus()
 	log.Println("MaxRequestBodySize:", config.MaxRequestBodySize)
 	log.Println("ReadTimeout:", config.ReadTimeout)
 	log.Println("WriteTimeout:", config.WriteTimeout)
+	log.Println("ShutdownTimeout:", config.ShutdownTimeout)
 	indexNamesLen := len(config.IndexNames)
 	if indexNamesLen > 0 {
 		log.Printf("Have %d index name(s):\n", indexNamesLen)
@@ -302,31 +274,222 @@ func main() {
 		log.Println("No any index names")
 	}
 
-	// map paths
-	if len(config.Paths) == 0 {
-		config.Paths["/"] = "."
+	// reload config on SIGHUP, and wait for the signal to shut down
+	watchConfig()
+	code := waitForShutdown(servers, errc)
+	closeLogFile()
+	os.Exit(code)
+}
+
+// defaultConfig returns the config with the default values of the options not given
+func defaultConfig() *Config {
+	return &Config{Verbose: true, EnableColor: true, EnableUpload: true, TusExpiry: tusDefaultExpiry}
+}
+
+// loadConfig loads the config, the precedence is flags > environment variables > config file > defaults
+func loadConfig(strict bool) (*Config, error) {
+	cfg := defaultConfig()
+	if len(*configFile) > 0 {
+		log.Println("Load config from:", *configFile)
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		// parse yaml, the unknown keys are errors in strict mode and warnings otherwise
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			if strict {
+				return nil, err
+			}
+			cfg = defaultConfig()
+			if err := yaml.Unmarshal(data, cfg); err != nil {
+				return nil, err
+			}
+			log.Printf("warning: %v\n", err)
+		}
+	}
+	if err := applyEnv(cfg, strict); err != nil {
+		return nil, err
+	}
+	if cfg.Paths == nil {
+		cfg.Paths = make(map[string]*Mount)
+	}
+	if len(*logFile) > 0 {
+		cfg.LogFile = *logFile
+	}
+	// overwrite config
+	if len(*addr) > 0 {
+		cfg.Addr = *addr
+	}
+	if len(*addrTLS) > 0 {
+		cfg.AddrTLS = *addrTLS
+	}
+	if len(cfg.Addr) == 0 && len(cfg.AddrTLS) == 0 {
+		cfg.Addr = ":8080"
+	}
+	if len(*certFile) > 0 {
+		cfg.CertFile = *certFile
+	}
+	if len(*keyFile) > 0 {
+		cfg.KeyFile = *keyFile
+	}
+	if len(*selfSigned) > 0 {
+		b, err := strconv.ParseBool(*selfSigned)
+		if err != nil {
+			return nil, fmt.Errorf("argument selfsigned error")
+		}
+		cfg.SelfSigned = b
+	}
+	if len(*username) > 0 {
+		cfg.Username = *username
+	}
+	if len(*password) > 0 {
+		cfg.Password = *password
+	}
+	if len(*htpasswdFile) > 0 {
+		cfg.HtpasswdFile = *htpasswdFile
 	}
-	for k, v := range config.Paths {
+	if len(*compress) > 0 {
+		b, err := strconv.ParseBool(*compress)
+		if err != nil {
+			return nil, fmt.Errorf("argument compress error")
+		}
+		cfg.Compress = b
+	}
+	if len(*path) > 0 {
+		cfg.Paths["/"] = &Mount{Root: *path}
+	}
+	if len(*indexNames) > 0 {
+		cfg.IndexNames = strings.Split(*indexNames, ",")
+	}
+	if len(*verbose) > 0 {
+		b, err := strconv.ParseBool(*verbose)
+		if err != nil {
+			return nil, fmt.Errorf("argument verbose error")
+		}
+		cfg.Verbose = b
+	}
+	if len(*fallback) > 0 {
+		cfg.Fallback = *fallback
+	}
+	if len(*enableColor) > 0 {
+		b, err := strconv.ParseBool(*enableColor)
+		if err != nil {
+			return nil, fmt.Errorf("argument enablecolor error")
+		}
+		cfg.EnableColor = b
+	}
+	if len(*enableUpload) > 0 {
+		b, err := strconv.ParseBool(*enableUpload)
+		if err != nil {
+			return nil, fmt.Errorf("argument enableupload error")
+		}
+		cfg.EnableUpload = b
+	}
+	if len(*maxRequestBodySize) > 0 {
+		i, err := strconv.Atoi(*maxRequestBodySize)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("argument maxrequestbodysize error")
+		}
+		cfg.MaxRequestBodySize = i
+	}
+	if cfg.MaxRequestBodySize < 0 {
+		return nil, fmt.Errorf("MaxRequestBodySize must be large or equal 0")
+	} else if cfg.MaxRequestBodySize == 0 {
+		cfg.MaxRequestBodySize = fasthttp.DefaultMaxRequestBodySize
+	}
+	if len(*readTimeout) > 0 {
+		i, err := time.ParseDuration(*readTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("argument readtimeout error")
+		}
+		cfg.ReadTimeout = i
+	}
+	if len(*writeTimeout) > 0 {
+		i, err := time.ParseDuration(*writeTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("argument writetimeout error")
+		}
+		cfg.WriteTimeout = i
+	}
+	if len(*shutdownTimeout) > 0 {
+		i, err := time.ParseDuration(*shutdownTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("argument shutdowntimeout error")
+		}
+		cfg.ShutdownTimeout = i
+	}
+	if err := checkHidePatterns(cfg.Hide); err != nil {
+		return nil, err
+	}
+	if err := checkSymlinkPolicy(cfg.Symlinks); err != nil {
+		return nil, err
+	}
+	if err := checkACMEConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := checkClientAuth(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// mapPaths returns the routes of config.Paths
+func mapPaths() *router {
+	r := &router{}
+	if len(config.Paths) == 0 {
+		config.Paths["/"] = &Mount{Root: "."}
+	}
+	keys := make([]string, 0, len(config.Paths))
+	for k := range config.Paths {
+		keys = append(keys, k)
+	}
+	// the first one of the duplicate paths is kept
+	sort.Strings(keys)
+	seen := make(map[string]string)
+	for _, k := range keys {
+		m := config.Paths[k]
+		if m == nil || len(m.Root) == 0 {
+			log.Printf("%s -> [ignored] root should not be empty\n", k)
+			delete(config.Paths, k)
+			continue
+		}
 		if !strings.HasPrefix(k, "/") {
-			log.Printf("%s -> %s [ignored] URI path should start with '/'\n", k, v)
+			log.Printf("%s -> %s [ignored] URI path should start with '/'\n", k, m.Root)
+			delete(config.Paths, k)
+			continue
+		}
+		if err := checkHidePatterns(m.Hide); err != nil {
+			log.Printf("%s -> %s [ignored] %v\n", k, m.Root, err)
+			delete(config.Paths, k)
+			continue
+		}
+		if err := checkSymlinkPolicy(m.Symlinks); err != nil {
+			log.Printf("%s -> %s [ignored] %v\n", k, m.Root, err)
+			delete(config.Paths, k)
+			continue
+		}
+		if dup, ok := seen[routePrefix(k)]; ok {
+			log.Printf("%s -> %s [ignored] duplicate of %s\n", k, m.Root, dup)
+			delete(config.Paths, k)
 			continue
 		}
-		if strings.HasPrefix(v, ".") {
-			if abs, err := filepath.Abs(v); err == nil {
-				v = abs
-				config.Paths[k] = v
+		seen[routePrefix(k)] = k
+		if strings.HasPrefix(m.Root, ".") {
+			if abs, err := filepath.Abs(m.Root); err == nil {
+				m.Root = abs
 			}
 		}
+		v := m.Root
 
 		fs := &fasthttp.FS{
 			Root:               v,
-			IndexNames:         config.IndexNames,
+			IndexNames:         m.indexNames(),
 			GenerateIndexPages: false,
 			AcceptByteRange:    true,
 		}
-		if len(config.Fallback) > 0 {
+		if fallback := m.fallback(); len(fallback) > 0 {
 			fs.PathNotFound = func(ctx *fasthttp.RequestCtx) {
-				fallbackpath := filepath.Join(v, config.Fallback)
+				fallbackpath := filepath.Join(v, fallback)
 				if fileIsExist(fallbackpath) {
 					mimeType := staticFileGetMimeType(filepath.Ext(fallbackpath))
 					if len(mimeType) > 0 {
@@ -343,20 +506,23 @@ func main() {
 			}
 		}
 
-		if k != "/" {
-			fs.PathRewrite = fasthttp.NewPathPrefixStripper(len(k))
+		prefix := strings.TrimRight(k, "/")
+		if len(prefix) > 0 {
+			fs.PathRewrite = fasthttp.NewPathPrefixStripper(len(prefix))
+		}
+		rt := r.add(k, m, fs.NewRequestHandler())
+		if m.WebDAV {
+			rt.dav = newDavHandler(rt.prefix, m)
 		}
-		fsMap[k] = fs.NewRequestHandler()
 		log.Printf("%s -> %s\n", k, v)
+		printMountOptions(m)
 	}
-	if len(fsMap) > 1 {
-		if v, found := config.Paths["/"]; found {
-			log.Printf("/ -> %s [ignored] root path overwrite /\n", v)
+	if len(r.routes) > 1 {
+		if rt := r.find("/"); rt != nil {
+			log.Printf("/ -> %s [partially ignored] root path lists the paths, other URIs fall back to it\n", rt.mount.Root)
 		}
 	}
-
-	// Wait forever.
-	select {}
+	return r
 }
 
 func printEnv(env string) {
@@ -367,162 +533,219 @@ func printEnv(env string) {
 }
 
 func requestHandler(ctx *fasthttp.RequestCtx) {
-	// auth
-	if enableBasicAuth {
-		user, pwd, ok := basicAuth(ctx)
-		if !ok || user != config.Username || pwd != config.Password {
-			statusCode := fasthttp.StatusUnauthorized
-			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
-			ctx.Response.Header.Set("WWW-Authenticate", "Basic realm=Restricted")
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	// the ACME HTTP-01 challenges are answered without auth
+	if acmeChallengeHandler != nil && strings.HasPrefix(string(ctx.Path()), acmeChallengePath) {
+		acmeChallengeHandler(ctx)
+		return
+	}
+	// the plain listener only redirects to the TLS listener
+	if redirectsToHTTPS(ctx) {
+		redirectHTTPS(ctx)
+		return
+	}
+	// set after the handlers, ctx.Error resets the headers
+	defer setHSTS(ctx)
+
+	// auth by the client certificate or basic auth, user is empty for anonymous
+	user, certAuth := clientCertUser(ctx)
+	if enableBasicAuth && !certAuth {
+		var pwd string
+		var ok bool
+		user, pwd, ok = basicAuth(ctx)
+		if ok && !users.verify(user, pwd) {
+			unauthorized(ctx)
 			// log print
 			if config.Verbose {
-				go logInfo(statusCode, "%d | %s | %s | %s | %s | %s \n",
-					statusCode, ctx.RemoteIP(), ctx.Method(), ctx.Path(), user, pwd)
+				statusCode := ctx.Response.StatusCode()
+				go logInfo(statusCode, "%d | %s | %s | %s | %s\n",
+					statusCode, ctx.RemoteIP(), ctx.Method(), ctx.Path(), user)
 			}
 			return
 		}
 	}
 
 	// router
-	switch string(ctx.Method()) {
-	case "POST":
-		switch string(ctx.Path()) {
+	path := string(ctx.Path())
+	method := string(ctx.Method())
+	isTus := config.EnableTus && pathHasPrefix(path, tusPath)
+	switch {
+	case !isTus && method == "PUT" && !isDavPath(path):
+		putHandle(ctx, user)
+		// the rest of the body is not read if it failed
+		if ctx.Response.StatusCode() >= 400 {
+			ctx.SetConnectionClose()
+		}
+	case !readRequestBody(ctx):
+		// the body is too large or broken
+	case isTus:
+		tusHandler(ctx, user)
+	case method == "POST":
+		switch path {
 		case "/ping":
-			{
+			if authorize(ctx, path, user, accessRead) {
 				fmt.Fprintf(ctx, `{"message":"pong","time":"`+ctx.Time().String()+`"}`)
 				ctx.SetContentType("application/json; charset=utf8")
 			}
 		case "/upload":
-			uploadHandle(ctx)
+			// anonymous can not upload, check it before parsing the form
+			if enableBasicAuth && len(user) == 0 {
+				unauthorized(ctx)
+			} else {
+				uploadHandle(ctx, user)
+			}
+		case fileOpsPath:
+			if enableBasicAuth && len(user) == 0 {
+				unauthorized(ctx)
+			} else {
+				fileOpsHandler(ctx, user)
+			}
 		default:
 			statusCode := fasthttp.StatusBadRequest
 			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
 		}
-	case "GET":
-		fsHandler(ctx)
+	case method == "GET":
+		if authorize(ctx, path, user, accessRead) {
+			fsHandler(ctx, user)
+		}
 	default:
-		statusCode := fasthttp.StatusNotFound
-		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		// PROPFIND, MKCOL, PUT, DELETE, MOVE, COPY, LOCK, UNLOCK, etc.
+		davHandler(ctx, user)
 	}
 
 	// log print
 	if config.Verbose {
 		statusCode := ctx.Response.StatusCode()
-		go logInfo(statusCode, "%d | %s | %s | %s\n", statusCode, ctx.RemoteIP(), ctx.Method(), ctx.Path())
+		go logInfo(statusCode, "%d | %s | %s | %s | %s\n", statusCode, ctx.RemoteIP(), ctx.Method(), ctx.Path(), user)
 	}
 }
 
-func fsHandler(ctx *fasthttp.RequestCtx) {
+// readRequestBody reads the streamed request body into memory, the size is limited by the largest
+// maxrequestbodysize, and the limit of each mount is checked by itself. It responds 413 and returns
+// false if the body is too large.
+func readRequestBody(ctx *fasthttp.RequestCtx) bool {
+	limit := serverMaxRequestBodySize()
+	if ctx.Request.Header.ContentLength() > limit {
+		statusCode := fasthttp.StatusRequestEntityTooLarge
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		ctx.SetConnectionClose()
+		return false
+	}
+	stream := ctx.RequestBodyStream()
+	if stream == nil {
+		return true
+	}
+	// the chunked body has no Content-Length
+	body, err := ioutil.ReadAll(io.LimitReader(stream, int64(limit)+1))
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		ctx.SetConnectionClose()
+		return false
+	}
+	if len(body) > limit {
+		statusCode := fasthttp.StatusRequestEntityTooLarge
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		ctx.SetConnectionClose()
+		return false
+	}
+	ctx.Request.SetBody(body)
+	return true
+}
+
+func fsHandler(ctx *fasthttp.RequestCtx, user string) {
 	path := string(ctx.Path())
-	if path == "/" && len(fsMap) > 1 {
-		fmt.Fprintf(ctx, "<html><head></head><body><h1>Root</h1><ul>")
-		for k, v := range config.Paths {
-			if !strings.HasPrefix(k, "/") || k == "/" {
+	if path == "/" && len(routes.routes) > 1 {
+		if ctx.QueryArgs().Has("archive") {
+			ctx.Error("The list of mapped paths can not be downloaded as an archive", fasthttp.StatusBadRequest)
+			return
+		}
+		listing := &dirListing{Path: path, Items: []dirEntry{}}
+		for _, rt := range routes.sorted() {
+			if rt.prefix == "/" || !isAllowed(rt.prefix, user, accessRead) {
 				continue
 			}
-			fmt.Fprintf(ctx, `<li><a href="%s">%s</a> -> %s</li>`, k, k, v)
+			if fi, err := os.Stat(rt.mount.Root); err == nil {
+				entry := newDirEntry(rt.prefix, fi)
+				entry.Name = rt.prefix[1:]
+				listing.Items = append(listing.Items, entry)
+			}
 		}
-		fmt.Fprintf(ctx, "</ul></body></html>")
-		ctx.SetContentType("text/html; charset=utf8")
+		writeListing(ctx, newListingPage(listing))
 		return
 	}
 
-	for k, handler := range fsMap {
-		if strings.HasPrefix(path, k) {
-			isDir, ok := dirHandler(path, ctx)
-			if ok {
-				return
-			}
-			if !isDir {
-				handler(ctx)
-				mimeType := staticFileGetMimeType(filepath.Ext(path))
-				if len(mimeType) > 0 {
-					ctx.SetContentType(mimeType)
-				}
-			}
-			return
-		}
+	rt := routes.match(path)
+	if rt == nil {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+		return
 	}
-
-	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
-}
-
-func dirHandler(path string, ctx *fasthttp.RequestCtx) (isDir bool, ok bool) {
-	var localpath string
-	for k, v := range config.Paths {
-		if strings.HasPrefix(path, k) {
-			localpath = filepath.Join(v, path[len(k):])
-			break
-		}
+	// the hidden paths and the symlinks not allowed are not found
+	if _, err := resolveLocalPath(rt, path); err != nil {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+		return
+	}
+	if archive, member, ok := findArchive(rt, path); ok {
+		archiveMemberHandler(ctx, rt, path, archive, member)
+		return
 	}
-	if len(localpath) == 0 {
+	isDir, ok := dirHandler(rt, path, ctx, user)
+	if ok {
 		return
 	}
+	if !isDir {
+		rt.handler(ctx)
+		mimeType := staticFileGetMimeType(filepath.Ext(path))
+		if len(mimeType) > 0 {
+			ctx.SetContentType(mimeType)
+		}
+		setCacheControl(ctx, rt.mount)
+	}
+}
+
+func dirHandler(rt *route, path string, ctx *fasthttp.RequestCtx, user string) (isDir bool, ok bool) {
+	m := rt.mount
+	localpath := filepath.Join(m.Root, rt.rel(path))
 
 	var err error
 	if dirIsExist(localpath) {
 		isDir = true
-		for _, v := range config.IndexNames {
+		if ctx.QueryArgs().Has("archive") {
+			archiveHandler(ctx, rt, path, localpath, user)
+			ok = true
+			return
+		}
+		for _, v := range m.indexNames() {
 			indexfile := filepath.Join(localpath, v)
-			if fileIsExist(indexfile) {
+			if fileIsExist(indexfile) && m.checkSymlinks(indexfile) == nil {
 				mimeType := staticFileGetMimeType(filepath.Ext(indexfile))
 				if len(mimeType) > 0 {
 					ctx.SetContentType(mimeType)
 				}
 				ctx.SendFile(indexfile)
+				setCacheControl(ctx, m)
 				ok = true
 				return
 			}
 		}
+		if !m.listingEnabled() {
+			statusCode := fasthttp.StatusForbidden
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+			ok = true
+			return
+		}
 
 		if ff, err := ioutil.ReadDir(localpath); err == nil {
-			path = strings.TrimRight(path, "/")
-			title := path[strings.LastIndex(path, "/")+1:]
-			parentLink := ""
-			if len(path) > 0 {
-				idx := strings.LastIndex(path, title)
-				var link string
-				if idx > 0 {
-					link = path[:idx]
-				} else {
-					link = "/"
-				}
-				parentLink = "<a href=\"" + link + "\"><b>..</b></a>"
-			}
-			if len(title) == 0 {
-				title = "Root"
-			}
-
-			var uploadhtml string
-			if config.EnableUpload {
-				uploadhtml = fmt.Sprintf(`<form enctype="multipart/form-data" action="/upload" method="post">`+
-					`<input name="files[]" type="file" multiple>`+
-					`<input type="submit" value="Upload" onclick="this.disabled=true;this.value='Sending...';"/>`+
-					`<input type="checkbox" name="o" value="true">Overwrite`+
-					`<input type="hidden" id="r" name="r" value="%s">`+
-					`<input type="hidden" id="p" name="p" value="%s"></form>`,
-					ctx.RequestURI(), localpath)
-			} else {
-				uploadhtml = ""
-			}
-
-			fmt.Fprintf(ctx, "<html><head><style>table{width:100%%;} th,td{text-align:left;padding-right:10px;} .size{text-align:right;} a{text-decoration:none} tr:hover{background-color:#ffff99;}</style>"+
-				"</head><body><h1>%s</h1>%s<p>%d item(s)</p><table>"+
-				"<tr><th>Name</th><th>Type</th><th>Mode</th><th class=\"size\">Size</th><th>Modified</th></tr>"+
-				"<tr><td>%s</td></tr>", title, uploadhtml, len(ff), parentLink)
-			for _, f := range ff {
-				filename := f.Name()
-				link := path + "/" + filename
-				if f.IsDir() {
-					fmt.Fprintf(ctx, "<tr><td><a href=\"%s\"><b>%s</b></a></td><td>dir</td><td>%s</td><td class=\"size\"</td><td>%s</td>",
-						link, filename, f.Mode().String(), f.ModTime())
-				} else {
-					fmt.Fprintf(ctx, "<tr><td><a href=\"%s\">%s</a></td><td>file</td><td>%s</td><td class=\"size\">%d</td><td>%s</td>",
-						link, filename, f.Mode().String(), f.Size(), f.ModTime())
-				}
-			}
-			fmt.Fprintf(ctx, "</table></body></html>")
-			ctx.SetContentType("text/html; charset=utf8")
+			ff = filterHidden(m, rt.rel(path), ff)
+			ff = filterSymlinks(m, localpath, ff)
+			page := newListingPage(newDirListing("/"+strings.Trim(path, "/"), ff))
+			page.Upload = m.uploadEnabled()
+			page.UploadURI = string(ctx.RequestURI())
+			page.FileOps = m.fileOpsEnabled()
+			page.Archive = true
+			writeListing(ctx, page)
+			setCacheControl(ctx, m)
 			ok = true
 			return
 		}
@@ -534,64 +757,6 @@ func dirHandler(path string, ctx *fasthttp.RequestCtx) (isDir bool, ok bool) {
 	return
 }
 
-func uploadHandle(ctx *fasthttp.RequestCtx) {
-	form, err := ctx.MultipartForm()
-	if err != nil {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		return
-	}
-
-	var uri, path string
-	isOverwrite := false
-	if r, ok := form.Value["r"]; ok && len(r) == 1 {
-		uri = r[0]
-	}
-	if len(uri) == 0 {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		return
-	}
-	if p, ok := form.Value["p"]; ok && len(p) == 1 {
-		path = p[0]
-	}
-	if len(path) == 0 {
-		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
-		return
-	}
-	if o, ok := form.Value["o"]; ok && len(o) == 1 {
-		isOverwrite = o[0] == "true"
-	}
-
-	for _, v := range form.File {
-		for _, header := range v {
-			fn := filepath.Join(path, header.Filename)
-			if !isOverwrite && fileOrDirIsExist(fn) {
-				for index := 1; index <= MaxInt; index++ {
-					ext := filepath.Ext(fn)
-					newfn := fmt.Sprintf("%s_%s_%d%s",
-						strings.TrimSuffix(fn, ext),
-						time.Now().Format("20060102150405"),
-						index,
-						ext)
-					if !fileOrDirIsExist(newfn) {
-						fn = newfn
-						break
-					}
-					if index == MaxInt {
-						ctx.Error("Sorry, can not create unique filename for "+fn, fasthttp.StatusInternalServerError)
-						return
-					}
-				}
-			}
-			logInfo(0, "%s | Saving file %s", ctx.RemoteIP(), fn)
-			err := fasthttp.SaveMultipartFile(header, fn)
-			if err != nil {
-				logInfo(fasthttp.StatusInternalServerError, "Save %s failed: %s", fn, err.Error())
-			}
-		}
-	}
-	ctx.Redirect(uri, fasthttp.StatusOK)
-}
-
 func dirIsExist(path string) bool {
 	if fi, err := os.Stat(path); err == nil {
 		return fi.IsDir()
@@ -613,6 +778,28 @@ func fileOrDirIsExist(path string) bool {
 	return false
 }
 
+// watchFile calls onChange when the modification time or size of path is changed
+func watchFile(path string, interval time.Duration, onChange func()) {
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	go func() {
+		for range time.Tick(interval) {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if fi.ModTime().Equal(modTime) && fi.Size() == size {
+				continue
+			}
+			modTime, size = fi.ModTime(), fi.Size()
+			onChange()
+		}
+	}()
+}
+
 func basicAuth(ctx *fasthttp.RequestCtx) (username, password string, ok bool) {
 	auth := ctx.Request.Header.Peek("Authorization")
 	if auth == nil {
@@ -652,6 +839,7 @@ func tryEnableLogFile() error {
 	if err != nil {
 		return err
 	}
+	logFileOutput = file
 	log.SetOutput(io.MultiWriter(file, os.Stdout))
 	log.Println("LogFile:", config.LogFile)
 	return nil
@@ -699,7 +887,7 @@ func getColor(statusCode int) color.Attribute {
 }
 
 func logInfo(statusCode int, format string, v ...interface{}) {
-	if !config.EnableColor {
+	if !colorOutput {
 		log.Printf(format, v...)
 	} else {
 		logMutex.Lock()
@@ -725,25 +913,107 @@ func makeConfigFile(configfile string) error {
 #addrtls: 0.0.0.0:8081
 #certfile: ./ssl-cert.pem
 #keyfile: ./ssl-cert.key
+# generate a self-signed certificate to certfile and keyfile, default is selfsigned.crt and selfsigned.key
+#selfsigned: true
+# the host names and IPs of the self-signed certificate besides localhost, the loopback IPs and the host name
+#selfsignedhosts: [files.example.lan]
+# add the IPs of local interfaces except link-local to the self-signed certificate,
+# it is generated again when they are changed
+#selfsignedips: true
+# more certificates chosen by the host names (SNI)
+#certificates:
+#  - certfile: ./example.org.pem
+#    keyfile: ./example.org.key
+# obtain the certificates by ACME
+#acmedomains: [files.example.org]
+#acmeemail: admin@example.org
+#acmedirectoryurl: https://acme-v02.api.letsencrypt.org/directory
+#acmecachedir: ./acme-cache
+# the CA of the ACME server, e.g. for the test server Pebble
+#acmecafile: ./pebble.minica.pem
+# verify the client certificates, require or optional
+#clientauth: require
+#clientcafile: ./clients-ca.pem
+# map the certificate CN= or DNS:, EMAIL:, URI:, IP: SANs to users
+#clientcertusers:
+#  "CN=backup-bot": backup
+# redirect addr to addrtls, except the ACME challenges
+#redirecthttps: true
+# the Strict-Transport-Security header of the TLS responses
+#hsts: max-age=31536000; includeSubDomains
 #username: admin
 #password: admin
+## htpasswd file supports bcrypt and {SHA} hashes, it is reloaded on change
+#htpasswdfile: ./htpasswd
+#users:
+  #alice: "$2y$10$..."
+  #bob: "{SHA}..."
+## access rules for URI paths, the longest matched path is used,
+## user is written as username, @group or * for any authenticated user
+#groups:
+  #ops: [alice, bob]
+#access:
+  #/public:
+    #anonymous: true
+  #/builds:
+    #upload: ["@ops"]
+  #/secrets:
+    #read: [alice, bob]
 compress: false
+## a path maps to a root directory, or an object to override the global options
 paths:
   #/c: "C:\\"
   #/d: "D:\\"
+  #/docs:
+    #root: ./docs
+    #indexnames: [index.html]
+    #fallback: ./index.html
+    #enableupload: false
+    #maxrequestbodysize: 0
+    #listing: false
+    #cachecontrol: max-age=3600
+    ## hide more names or paths in this path
+    #hide: ["*.log"]
+    #showdotfiles: true
+    #symlinks: deny
+    ## map as a network drive by WebDAV, writing needs upload enabled
+    #webdav: true
 indexnames:
   - index.html
   - index.htm
 verbose: true
 enablecolor: true
+## the directory of listing.html template and style.css for the directory listing
+#templatedir: ./theme
+## the items per page of directory listing, 0 for all items in one page
+listingpagesize: 0
 enableupload: true
+## create, rename, move and delete files from the directory listing or /fileops API
+#enablefileops: true
+## resumable upload by tus protocol at /tus, the partial uploads are saved in tusdir
+#enabletus: true
+#tusdir: ./tus
+## the max Upload-Length in bytes, 0 is unlimited
+#tusmaxsize: 10737418240
+## the partial uploads not changed in tusexpiry are removed, 0s never expires
+#tusexpiry: 24h
 ## maxrequestbodysize 0 to default size
 maxrequestbodysize: %d
 ## timeout 0s is unlimited
 readtimeout: 0s
 writetimeout: 0s
+## the time to finish active requests on SIGINT or SIGTERM, 0s is unlimited
+shutdowntimeout: 30s
+## reload config when this file is changed, it is also reloaded on SIGHUP
+#watchconfig: true
 logfile: ./simplehttpserver.log
 #fallback: ./index.html
+## the dotfiles are hidden unless showdotfiles is true,
+## the names or paths match hide patterns are hidden in all paths, they are not listed and return 404
+showdotfiles: false
+#hide: ["*.bak", "*~", "node_modules", "/build/tmp"]
+## follow the symlinks in paths: follow all, within the root (default), or deny all
+#symlinks: within
 #HTTP_PROXY:
 #HTTPS_PROXY:
 #NO_PROXY: ::1,127.0.0.1,localhost`, MaxInt))
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Mount is a local directory mapped to an URI path.
+// The options left empty inherit the global config.
+// In the config file it can also be written as the root path only, e.g.:
+//
+//	/d: "D:\\"
+//
+// The empty options are omitted in JSON for logging the config changes.
+type Mount struct {
+	Root               string   `json:",omitempty"`
+	IndexNames         []string `json:",omitempty"`
+	Fallback           string   `json:",omitempty"`
+	EnableUpload       *bool    `json:",omitempty"`
+	EnableFileOps      *bool    `json:",omitempty"`
+	MaxRequestBodySize int      `json:",omitempty"`
+	Listing            *bool    `json:",omitempty"`
+	CacheControl       string   `json:",omitempty"`
+	// Hide patterns are added to the global patterns
+	Hide         []string `json:",omitempty"`
+	ShowDotfiles *bool    `json:",omitempty"`
+	// Symlinks is the symlink policy "follow", "within" or "deny"
+	Symlinks string `json:",omitempty"`
+	WebDAV   bool   `json:",omitempty"`
+}
+
+// UnmarshalYAML accepts both the string and the object form
+func (m *Mount) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var root string
+	if err := unmarshal(&root); err == nil {
+		*m = Mount{Root: root}
+		return nil
+	}
+	// the strict parser reports the unknown keys in type main.mount
+	type mount Mount
+	return unmarshal((*mount)(m))
+}
+
+func (m *Mount) indexNames() []string {
+	if m.IndexNames != nil {
+		return m.IndexNames
+	}
+	return config.IndexNames
+}
+
+func (m *Mount) fallback() string {
+	if len(m.Fallback) > 0 {
+		return m.Fallback
+	}
+	return config.Fallback
+}
+
+func (m *Mount) uploadEnabled() bool {
+	if m.EnableUpload != nil {
+		return *m.EnableUpload
+	}
+	return config.EnableUpload
+}
+
+// fileOpsEnabled reports whether m allows the file operations, they need basic authorization
+func (m *Mount) fileOpsEnabled() bool {
+	if !enableBasicAuth {
+		return false
+	}
+	if m.EnableFileOps != nil {
+		return *m.EnableFileOps
+	}
+	return config.EnableFileOps
+}
+
+func (m *Mount) maxRequestBodySize() int {
+	if m.MaxRequestBodySize > 0 {
+		return m.MaxRequestBodySize
+	}
+	return config.MaxRequestBodySize
+}
+
+// listingEnabled reports whether to list the directories without index file
+func (m *Mount) listingEnabled() bool {
+	if m.Listing != nil {
+		return *m.Listing
+	}
+	return true
+}
+
+// serverMaxRequestBodySize returns the largest body size of the global config and mounts,
+// the limit of each mount is checked by itself
+func serverMaxRequestBodySize() int {
+	size := config.MaxRequestBodySize
+	for _, m := range config.Paths {
+		if m.MaxRequestBodySize > size {
+			size = m.MaxRequestBodySize
+		}
+	}
+	return size
+}
+
+func setCacheControl(ctx *fasthttp.RequestCtx, m *Mount) {
+	if m == nil || len(m.CacheControl) == 0 {
+		return
+	}
+	if statusCode := ctx.Response.StatusCode(); statusCode < 400 {
+		ctx.Response.Header.Set("Cache-Control", m.CacheControl)
+	}
+}
+
+func printMountOptions(m *Mount) {
+	if m.IndexNames != nil {
+		log.Println("   IndexNames:", m.IndexNames)
+	}
+	if len(m.Fallback) > 0 {
+		log.Println("   Fallback:", m.Fallback)
+	}
+	if m.EnableUpload != nil {
+		log.Println("   EnableUpload:", *m.EnableUpload)
+	}
+	if m.EnableFileOps != nil {
+		log.Println("   EnableFileOps:", *m.EnableFileOps)
+	}
+	if m.MaxRequestBodySize > 0 {
+		log.Println("   MaxRequestBodySize:", m.MaxRequestBodySize)
+	}
+	if m.Listing != nil {
+		log.Println("   Listing:", *m.Listing)
+	}
+	if len(m.CacheControl) > 0 {
+		log.Println("   CacheControl:", m.CacheControl)
+	}
+	if len(m.Hide) > 0 {
+		log.Println("   Hide:", m.Hide)
+	}
+	if m.ShowDotfiles != nil {
+		log.Println("   ShowDotfiles:", *m.ShowDotfiles)
+	}
+	if len(m.Symlinks) > 0 {
+		log.Println("   Symlinks:", m.Symlinks)
+	}
+	if m.WebDAV {
+		log.Println("   WebDAV:", m.WebDAV)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// redirectsToHTTPS reports whether the plain listener only redirects the request to the TLS listener,
+// the ACME HTTP-01 challenges are not redirected
+func redirectsToHTTPS(ctx *fasthttp.RequestCtx) bool {
+	return config.RedirectHTTPS && len(config.AddrTLS) > 0 && !ctx.IsTLS() &&
+		!strings.HasPrefix(string(ctx.Path()), acmeChallengePath)
+}
+
+// redirectHTTPS redirects to the same host, path and query on the port of config.AddrTLS
+func redirectHTTPS(ctx *fasthttp.RequestCtx) {
+	host := string(ctx.Host())
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.Trim(host, "[]")
+	}
+	if _, port, err := net.SplitHostPort(config.AddrTLS); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	statusCode := fasthttp.StatusMovedPermanently
+	if !ctx.IsGet() && !ctx.IsHead() {
+		// keep the method and body
+		statusCode = fasthttp.StatusPermanentRedirect
+	}
+	ctx.Redirect("https://"+host+string(ctx.RequestURI()), statusCode)
+}
+
+// setHSTS sets the Strict-Transport-Security header of the TLS responses by config.HSTS
+func setHSTS(ctx *fasthttp.RequestCtx) {
+	if len(config.HSTS) > 0 && ctx.IsTLS() {
+		ctx.Response.Header.Set("Strict-Transport-Security", config.HSTS)
+	}
+}
+
+func printRedirect() {
+	if config.RedirectHTTPS && len(config.Addr) > 0 && len(config.AddrTLS) > 0 {
+		log.Println("RedirectHTTPS:", config.RedirectHTTPS)
+	}
+	if len(config.HSTS) > 0 && len(config.AddrTLS) > 0 {
+		log.Println("HSTS:", config.HSTS)
+	}
+}
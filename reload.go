@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configMutex is held for reading by each request and for writing by reloading config,
+// so a request sees either the old or the new config, routes and users
+var configMutex sync.RWMutex
+
+// restartOptions are the options of Config which are applied by restarting the server only
+var restartOptions = []string{
+	"Addr", "AddrTLS", "Compress", "LogFile", "EnableColor",
+	"MaxRequestBodySize", "ReadTimeout", "WriteTimeout", "HTTPProxy", "HTTPSProxy", "NoProxy",
+	"ACMEDomains", "ACMEEmail", "ACMEDirectoryURL", "ACMECacheDir", "ACMECAFile",
+	"ClientAuth", "ClientCAFile",
+}
+
+// secretOptions are not printed when they are changed
+var secretOptions = map[string]bool{"Password": true, "Users": true}
+
+// applyConfig loads the users, templates and certificates of config and maps the paths,
+// nothing is changed if any of them is invalid
+func applyConfig() error {
+	hashes, err := readUsers()
+	if err != nil {
+		return err
+	}
+	var certList []*tls.Certificate
+	if len(config.AddrTLS) > 0 {
+		if err := prepareSelfSigned(); err != nil {
+			return err
+		}
+		if certList, err = readCertificates(); err != nil {
+			return err
+		}
+	}
+	t, css, err := loadTemplates()
+	if err != nil {
+		return err
+	}
+	if err := prepareTusDir(); err != nil {
+		return err
+	}
+	users.set(hashes)
+	if certList != nil {
+		certs.set(certList)
+	}
+	listingTemplate, listingCSS = t, css
+	routes = mapPaths()
+	enableBasicAuth = len(config.Username) > 0 && len(config.Password) > 0 ||
+		len(config.HtpasswdFile) > 0 || len(hashes) > 0 || len(config.ClientCertUsers) > 0
+	return nil
+}
+
+// watchConfig reloads config on SIGHUP,
+// or when the config file is changed if config.WatchConfig is true
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	changed := make(chan struct{}, 1)
+	if len(*configFile) > 0 {
+		watchFile(*configFile, 2*time.Second, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+	go func() {
+		for {
+			select {
+			case sig := <-hup:
+				reloadConfig(sig.String())
+			case <-changed:
+				configMutex.RLock()
+				watch := config.WatchConfig
+				configMutex.RUnlock()
+				if watch {
+					reloadConfig("file change")
+				}
+			}
+		}
+	}()
+}
+
+// reloadConfig loads the config strictly, validates and applies it,
+// the old config is kept if the new one has any error, e.g. a typo in the key of a path
+func reloadConfig(reason string) {
+	log.Printf("Reload config by %s\n", reason)
+	cfg, err := loadConfig(true)
+	if err != nil {
+		log.Printf("Reload config failed, keep the old config: %v\n", err)
+		return
+	}
+	if errs := validateConfig(cfg); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("error: %v\n", err)
+		}
+		log.Println("Reload config failed, keep the old config")
+		return
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	old := config
+	keepRestartOptions(old, cfg)
+	config = cfg
+	if err := applyConfig(); err != nil {
+		config = old
+		log.Printf("Reload config failed, keep the old config: %v\n", err)
+		return
+	}
+	watchHtpasswd()
+	watchCertificates()
+	logConfigChanges(old, cfg)
+}
+
+// keepRestartOptions sets the restart options of cfg to the old values
+func keepRestartOptions(old, cfg *Config) {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem()
+	for _, name := range restartOptions {
+		if !reflect.DeepEqual(o.FieldByName(name).Interface(), n.FieldByName(name).Interface()) {
+			log.Printf("Config %s is changed, restart the server to apply it\n", name)
+			n.FieldByName(name).Set(o.FieldByName(name))
+		}
+	}
+}
+
+func logConfigChanges(old, cfg *Config) {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(cfg).Elem()
+	changed := false
+	for i := 0; i < n.NumField(); i++ {
+		ov, nv := o.Field(i).Interface(), n.Field(i).Interface()
+		if reflect.DeepEqual(ov, nv) {
+			continue
+		}
+		changed = true
+		name := n.Type().Field(i).Name
+		switch {
+		case secretOptions[name]:
+			log.Printf("Config %s is changed\n", name)
+		case n.Field(i).Kind() == reflect.Map:
+			logMapChanges(name, o.Field(i), n.Field(i))
+		default:
+			log.Printf("Config %s: %s -> %s\n", name, formatOption(ov), formatOption(nv))
+		}
+	}
+	if !changed {
+		log.Println("Config is not changed")
+	}
+}
+
+// logMapChanges logs the added, removed and changed keys of map option
+func logMapChanges(name string, old, cur reflect.Value) {
+	for _, k := range old.MapKeys() {
+		if !cur.MapIndex(k).IsValid() {
+			log.Printf("Config %s[%v] is removed\n", name, k)
+		}
+	}
+	for _, k := range cur.MapKeys() {
+		ov, nv := old.MapIndex(k), cur.MapIndex(k)
+		if !ov.IsValid() {
+			log.Printf("Config %s[%v] is added: %s\n", name, k, formatOption(nv.Interface()))
+		} else if !reflect.DeepEqual(ov.Interface(), nv.Interface()) {
+			log.Printf("Config %s[%v]: %s -> %s\n", name, k, formatOption(ov.Interface()), formatOption(nv.Interface()))
+		}
+	}
+}
+
+// formatOption formats the maps, slices and pointers as JSON
+func formatOption(v interface{}) string {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr:
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// route is a mount with its URI path prefix and handlers
+type route struct {
+	prefix  string
+	mount   *Mount
+	handler fasthttp.RequestHandler
+	// dav is nil if WebDAV is not enabled
+	dav func(ctx *fasthttp.RequestCtx, user string)
+}
+
+// router matches the routes by the longest URI path prefix on path segment boundaries,
+// e.g. /docs/x matches /docs rather than /d
+type router struct {
+	routes []*route
+}
+
+// routePrefix returns prefix without the trailing slashes,
+// the paths with the same route prefix are duplicates, e.g. /docs and /docs/
+func routePrefix(prefix string) string {
+	if prefix != "/" {
+		prefix = strings.TrimRight(prefix, "/")
+	}
+	return prefix
+}
+
+// add adds a route, the trailing slashes of prefix are ignored
+func (r *router) add(prefix string, m *Mount, handler fasthttp.RequestHandler) *route {
+	prefix = routePrefix(prefix)
+	rt := &route{prefix: prefix, mount: m, handler: handler}
+	r.routes = append(r.routes, rt)
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+	return rt
+}
+
+// match returns the route of the longest prefix which matches path, or nil
+func (r *router) match(path string) *route {
+	for _, rt := range r.routes {
+		if pathHasPrefix(path, rt.prefix) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// find returns the route of prefix, or nil
+func (r *router) find(prefix string) *route {
+	for _, rt := range r.routes {
+		if rt.prefix == prefix {
+			return rt
+		}
+	}
+	return nil
+}
+
+// sorted returns the routes sorted by prefix
+func (r *router) sorted() []*route {
+	routes := make([]*route, len(r.routes))
+	copy(routes, r.routes)
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].prefix < routes[j].prefix
+	})
+	return routes
+}
+
+// rel returns the path relative to the route prefix
+func (rt *route) rel(path string) string {
+	if rt.prefix == "/" {
+		return path
+	}
+	return path[len(rt.prefix):]
+}
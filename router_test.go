@@ -0,0 +1,118 @@
+package main
+
+import "testing"
+
+func newTestRouter(prefixes ...string) *router {
+	r := &router{}
+	for _, prefix := range prefixes {
+		r.add(prefix, &Mount{Root: prefix}, nil)
+	}
+	return r
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := newTestRouter("/", "/d", "/docs", "/docsx", "/files/")
+	tests := []struct {
+		path   string
+		prefix string
+	}{
+		{"/", "/"},
+		{"/index.html", "/"},
+		{"/d", "/d"},
+		{"/d/a.txt", "/d"},
+		{"/da", "/"},
+		{"/docs", "/docs"},
+		{"/docs/", "/docs"},
+		{"/docs/a/b.txt", "/docs"},
+		{"/docsx", "/docsx"},
+		{"/docsx/a.txt", "/docsx"},
+		{"/docsy", "/"},
+		{"/files", "/files"},
+		{"/files/a.txt", "/files"},
+		{"/filesx", "/"},
+	}
+	for _, tt := range tests {
+		rt := r.match(tt.path)
+		if rt == nil {
+			t.Errorf("match(%q) = nil, want %q", tt.path, tt.prefix)
+			continue
+		}
+		if rt.prefix != tt.prefix {
+			t.Errorf("match(%q) = %q, want %q", tt.path, rt.prefix, tt.prefix)
+		}
+	}
+}
+
+func TestRouterMatchWithoutRoot(t *testing.T) {
+	r := newTestRouter("/docs")
+	for _, path := range []string{"/", "/doc", "/docsx", "/other/docs"} {
+		if rt := r.match(path); rt != nil {
+			t.Errorf("match(%q) = %q, want nil", path, rt.prefix)
+		}
+	}
+}
+
+func TestRouterAdd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"/", "/"},
+		{"/docs", "/docs"},
+		{"/docs/", "/docs"},
+		{"/docs//", "/docs"},
+	}
+	for _, tt := range tests {
+		r := &router{}
+		if rt := r.add(tt.prefix, &Mount{}, nil); rt.prefix != tt.want {
+			t.Errorf("add(%q).prefix = %q, want %q", tt.prefix, rt.prefix, tt.want)
+		}
+		if r.find(tt.want) == nil {
+			t.Errorf("find(%q) = nil after add(%q)", tt.want, tt.prefix)
+		}
+	}
+}
+
+// the longest prefix wins whatever the order of adding
+func TestRouterOrderIndependence(t *testing.T) {
+	orders := [][]string{
+		{"/", "/d", "/docs", "/docs/api"},
+		{"/docs/api", "/docs", "/d", "/"},
+		{"/docs", "/", "/docs/api", "/d"},
+	}
+	paths := map[string]string{
+		"/x":              "/",
+		"/d/x":            "/d",
+		"/docs/x":         "/docs",
+		"/docs/api":       "/docs/api",
+		"/docs/api/v1":    "/docs/api",
+		"/docs/apix/v1":   "/docs",
+		"/docsapi/v1":     "/",
+		"/d/docs/api/foo": "/d",
+	}
+	for _, order := range orders {
+		r := newTestRouter(order...)
+		for path, want := range paths {
+			if rt := r.match(path); rt == nil || rt.prefix != want {
+				t.Errorf("routes %v: match(%q) = %v, want %q", order, path, rt, want)
+			}
+		}
+	}
+}
+
+func TestRouteRel(t *testing.T) {
+	r := newTestRouter("/", "/docs")
+	tests := []struct {
+		path string
+		rel  string
+	}{
+		{"/a.txt", "/a.txt"},
+		{"/docs", ""},
+		{"/docs/a.txt", "/a.txt"},
+	}
+	for _, tt := range tests {
+		if rel := r.match(tt.path).rel(tt.path); rel != tt.rel {
+			t.Errorf("rel(%q) = %q, want %q", tt.path, rel, tt.rel)
+		}
+	}
+}
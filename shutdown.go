@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// logFileOutput is the opened config.LogFile, it is flushed and closed on exit
+var logFileOutput *os.File
+
+// connTracker tracks the idle connections, they are closed on shutdown
+// because the keep-alive connections waiting for the next request can not be drained
+type connTracker struct {
+	mu       sync.Mutex
+	idle     map[net.Conn]struct{}
+	draining bool
+}
+
+var conns = &connTracker{idle: make(map[net.Conn]struct{})}
+
+func (t *connTracker) connState(c net.Conn, state fasthttp.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case fasthttp.StateNew, fasthttp.StateIdle:
+		if t.draining {
+			c.Close()
+			return
+		}
+		t.idle[c] = struct{}{}
+	default:
+		delete(t.idle, c)
+	}
+}
+
+// drain closes the idle connections now and the connections become idle later
+func (t *connTracker) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+	for c := range t.idle {
+		c.Close()
+		delete(t.idle, c)
+	}
+}
+
+func newServer(h fasthttp.RequestHandler, maxBodySize int) *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:            h,
+		MaxRequestBodySize: maxBodySize,
+		ReadTimeout:        config.ReadTimeout,
+		WriteTimeout:       config.WriteTimeout,
+		ConnState:          conns.connState,
+		// the PUT uploads are streamed to the files, other bodies are read by readRequestBody
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	}
+}
+
+// waitForShutdown waits for SIGINT, SIGTERM or an error of servers, then shuts down the servers,
+// it returns the exit code, 0 if all active requests are finished in config.ShutdownTimeout
+func waitForShutdown(servers []*fasthttp.Server, errc <-chan error) int {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	select {
+	case sig := <-sigc:
+		log.Printf("Received %v, shutting down\n", sig)
+	case err := <-errc:
+		log.Printf("error: %v", err)
+		code = 1
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, server := range servers {
+			wg.Add(1)
+			go func(server *fasthttp.Server) {
+				defer wg.Done()
+				if err := server.Shutdown(); err != nil {
+					log.Printf("error in Shutdown: %v", err)
+				}
+			}(server)
+		}
+		conns.drain()
+		wg.Wait()
+		close(done)
+	}()
+	configMutex.RLock()
+	shutdownTimeout := config.ShutdownTimeout
+	configMutex.RUnlock()
+	var timeout <-chan time.Time
+	if shutdownTimeout > 0 {
+		timeout = time.After(shutdownTimeout)
+	}
+	select {
+	case <-done:
+		log.Println("Server stopped")
+		return code
+	case <-timeout:
+		log.Printf("Shutdown timeout %v, the active requests are aborted\n", shutdownTimeout)
+	case sig := <-sigc:
+		log.Printf("Received %v again, the active requests are aborted\n", sig)
+	}
+	return 1
+}
+
+// closeLogFile flushes and closes the log file
+func closeLogFile() {
+	if logFileOutput == nil {
+		return
+	}
+	log.SetOutput(os.Stdout)
+	_ = logFileOutput.Sync()
+	_ = logFileOutput.Close()
+	logFileOutput = nil
+}
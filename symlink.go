@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the symlink policies
+const (
+	// symlinksFollow follows all symlinks
+	symlinksFollow = "follow"
+	// symlinksWithin follows the symlinks whose targets are within the root
+	symlinksWithin = "within"
+	// symlinksDeny denies all symlinks
+	symlinksDeny = "deny"
+)
+
+var errSymlink = errors.New("symlink is not allowed")
+
+func checkSymlinkPolicy(policy string) error {
+	switch policy {
+	case "", symlinksFollow, symlinksWithin, symlinksDeny:
+		return nil
+	}
+	return errors.New("symlinks should be follow, within or deny")
+}
+
+// symlinkPolicy returns the policy of mount, default is within
+func (m *Mount) symlinkPolicy() string {
+	if len(m.Symlinks) > 0 {
+		return m.Symlinks
+	}
+	if len(config.Symlinks) > 0 {
+		return config.Symlinks
+	}
+	return symlinksWithin
+}
+
+// checkSymlinks returns errSymlink if the local path goes through a symlink below the root
+// which is not allowed by the policy, the missing elements are not checked
+func (m *Mount) checkSymlinks(local string) error {
+	policy := m.symlinkPolicy()
+	if policy == symlinksFollow {
+		return nil
+	}
+	rel, err := filepath.Rel(m.Root, local)
+	if err != nil || !isWithin(m.Root, local) {
+		return errOutsideRoot
+	}
+	if rel == "." {
+		return nil
+	}
+	var root string
+	path := m.Root
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if policy == symlinksDeny {
+			return errSymlink
+		}
+		if len(root) == 0 {
+			if root, err = filepath.EvalSymlinks(m.Root); err != nil {
+				return err
+			}
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil || !isWithin(root, target) {
+			return errSymlink
+		}
+	}
+	return nil
+}
+
+// filterSymlinks removes the symlinks which are not allowed from the files of local directory dir,
+// the allowed symlinks are replaced by the info of their targets
+func filterSymlinks(m *Mount, dir string, ff []os.FileInfo) []os.FileInfo {
+	allowed := ff[:0]
+	for _, f := range ff {
+		if f.Mode()&os.ModeSymlink != 0 {
+			local := filepath.Join(dir, f.Name())
+			if m.checkSymlinks(local) != nil {
+				continue
+			}
+			fi, err := os.Stat(local)
+			if err != nil {
+				continue
+			}
+			f = fi
+		}
+		allowed = append(allowed, f)
+	}
+	return allowed
+}
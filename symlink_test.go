@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// makeSymlinkTree creates the tree in a temp directory and returns it:
+//
+//	root/file.txt
+//	root/dir/inner.txt
+//	root/in -> file.txt
+//	root/indir -> dir
+//	root/out -> ../outside/secret.txt
+//	root/outdir -> ../outside
+//	root/dangling -> ../outside/missing.txt
+//	outside/secret.txt
+//	rootlink -> root
+func makeSymlinkTree(t *testing.T) string {
+	base, err := ioutil.TempDir("", "simplehttpserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"root/dir", "outside"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"root/file.txt", "root/dir/inner.txt", "outside/secret.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(base, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := [][2]string{
+		{"file.txt", "root/in"},
+		{"dir", "root/indir"},
+		{"../outside/secret.txt", "root/out"},
+		{"../outside", "root/outdir"},
+		{"../outside/missing.txt", "root/dangling"},
+		{"root", "rootlink"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], filepath.Join(base, link[1])); err != nil {
+			t.Skip("symlink is not supported:", err)
+		}
+	}
+	return base
+}
+
+func TestCheckSymlinks(t *testing.T) {
+	base := makeSymlinkTree(t)
+	defer os.RemoveAll(base)
+
+	tests := []struct {
+		path   string
+		follow error
+		within error
+		deny   error
+	}{
+		{"file.txt", nil, nil, nil},
+		{"dir/inner.txt", nil, nil, nil},
+		// the link inside the root
+		{"in", nil, nil, errSymlink},
+		{"indir/inner.txt", nil, nil, errSymlink},
+		// the links escaping the root
+		{"out", nil, errSymlink, errSymlink},
+		{"outdir/secret.txt", nil, errSymlink, errSymlink},
+		{"dangling", nil, errSymlink, errSymlink},
+		// the missing final element is not checked
+		{"missing.txt", nil, nil, nil},
+		{"dir/missing.txt", nil, nil, nil},
+		{"indir/missing.txt", nil, nil, errSymlink},
+		{"outdir/missing.txt", nil, errSymlink, errSymlink},
+		{"../outside/secret.txt", nil, errOutsideRoot, errOutsideRoot},
+	}
+	for _, root := range []string{"root", "rootlink"} {
+		for _, tt := range tests {
+			for policy, want := range map[string]error{
+				symlinksFollow: tt.follow,
+				symlinksWithin: tt.within,
+				symlinksDeny:   tt.deny,
+			} {
+				m := &Mount{Root: filepath.Join(base, root), Symlinks: policy}
+				local := filepath.Join(m.Root, filepath.FromSlash(tt.path))
+				if err := m.checkSymlinks(local); err != want {
+					t.Errorf("%s %s: checkSymlinks(%q) = %v, want %v", root, policy, tt.path, err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSymlinkPolicyDefault(t *testing.T) {
+	defer func(symlinks string) { config.Symlinks = symlinks }(config.Symlinks)
+
+	config.Symlinks = ""
+	if policy := (&Mount{}).symlinkPolicy(); policy != symlinksWithin {
+		t.Errorf("default policy = %q, want %q", policy, symlinksWithin)
+	}
+	config.Symlinks = symlinksDeny
+	if policy := (&Mount{}).symlinkPolicy(); policy != symlinksDeny {
+		t.Errorf("global policy = %q, want %q", policy, symlinksDeny)
+	}
+	if policy := (&Mount{Symlinks: symlinksFollow}).symlinkPolicy(); policy != symlinksFollow {
+		t.Errorf("mount policy = %q, want %q", policy, symlinksFollow)
+	}
+}
+
+func TestFilterSymlinks(t *testing.T) {
+	base := makeSymlinkTree(t)
+	defer os.RemoveAll(base)
+	root := filepath.Join(base, "root")
+
+	tests := []struct {
+		policy string
+		names  []string
+	}{
+		{symlinksFollow, []string{"dir", "file.txt", "in", "indir", "out", "outdir"}},
+		{symlinksWithin, []string{"dir", "file.txt", "in", "indir"}},
+		{symlinksDeny, []string{"dir", "file.txt"}},
+	}
+	for _, tt := range tests {
+		ff, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Mount{Root: root, Symlinks: tt.policy}
+		ff = filterSymlinks(m, root, ff)
+		var names []string
+		for _, f := range ff {
+			names = append(names, f.Name())
+			// the allowed symlinks are replaced by their targets
+			if f.Mode()&os.ModeSymlink != 0 {
+				t.Errorf("%s: %s is a symlink", tt.policy, f.Name())
+			}
+			if isDir := f.Name() == "dir" || f.Name() == "indir" || f.Name() == "outdir"; f.IsDir() != isDir {
+				t.Errorf("%s: %s IsDir() = %v, want %v", tt.policy, f.Name(), f.IsDir(), isDir)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: filterSymlinks = %v, want %v", tt.policy, names, tt.names)
+		}
+	}
+}
+
+func TestCheckSymlinkPolicy(t *testing.T) {
+	for _, policy := range []string{"", symlinksFollow, symlinksWithin, symlinksDeny} {
+		if err := checkSymlinkPolicy(policy); err != nil {
+			t.Errorf("checkSymlinkPolicy(%q) = %v", policy, err)
+		}
+	}
+	if err := checkSymlinkPolicy("allow"); err == nil {
+		t.Error("checkSymlinkPolicy(\"allow\") = nil, want an error")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/url"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	listingTemplate *template.Template
+	listingCSS      template.CSS
+)
+
+// listingPage is the data of listing template
+type listingPage struct {
+	*dirListing
+	Title       string
+	Breadcrumbs []breadcrumb
+	// Parent is the link of parent directory, empty for the root
+	Parent      string
+	Upload      bool
+	UploadURI   string
+	FileOps     bool
+	FileOpsPath string
+	// Archive shows the links to download the directory as an archive
+	Archive bool
+	CSS     template.CSS
+	// SortLinks are the links to sort by "name", "size", "mtime" and "type",
+	// the order is reversed for the current sort
+	SortLinks map[string]string
+	Pages     int
+	// PrevLink and NextLink are the links of pages, empty for no page
+	PrevLink string
+	NextLink string
+}
+
+type breadcrumb struct {
+	Name string
+	Link string
+}
+
+func newListingPage(listing *dirListing) *listingPage {
+	page := &listingPage{
+		dirListing:  listing,
+		Title:       "Root",
+		Breadcrumbs: []breadcrumb{{Name: "Root", Link: "/"}},
+		FileOpsPath: fileOpsPath,
+		CSS:         listingCSS,
+	}
+	if listing.Path == "/" {
+		return page
+	}
+	names := strings.Split(strings.Trim(listing.Path, "/"), "/")
+	for i, name := range names {
+		link := "/" + strings.Join(names[:i+1], "/")
+		page.Breadcrumbs = append(page.Breadcrumbs, breadcrumb{
+			Name: name,
+			Link: (&url.URL{Path: link}).EscapedPath(),
+		})
+	}
+	page.Title = names[len(names)-1]
+	page.Parent = page.Breadcrumbs[len(page.Breadcrumbs)-2].Link
+	return page
+}
+
+// writeListing applies the listing options of query,
+// then writes the page as JSON or HTML by wantsJSON
+func writeListing(ctx *fasthttp.RequestCtx, page *listingPage) {
+	o, err := parseListingOptions(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	page.apply(o)
+	page.Pages = page.pages()
+	page.SortLinks = make(map[string]string)
+	for _, v := range []string{"name", "size", "mtime", "type"} {
+		order := "asc"
+		if v == o.Sort && o.Order == "asc" {
+			order = "desc"
+		}
+		page.SortLinks[v] = listingLink(ctx, o, v, order, 1)
+	}
+	if o.Page > 1 {
+		page.PrevLink = listingLink(ctx, o, o.Sort, o.Order, o.Page-1)
+	}
+	if o.Page < page.Pages {
+		page.NextLink = listingLink(ctx, o, o.Sort, o.Order, o.Page+1)
+	}
+
+	if wantsJSON(ctx) {
+		writeJSON(ctx, fasthttp.StatusOK, page.dirListing)
+		return
+	}
+	var buf bytes.Buffer
+	if err := listingTemplate.Execute(&buf, page); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("text/html; charset=utf8")
+	ctx.SetBody(buf.Bytes())
+}
+
+// listingLink returns the link of current path with the listing options
+func listingLink(ctx *fasthttp.RequestCtx, o *listingOptions, sort, order string, page int) string {
+	query := url.Values{}
+	query.Set("sort", sort)
+	query.Set("order", order)
+	if len(o.Filter) > 0 {
+		query.Set("filter", o.Filter)
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if o.PerPage != config.ListingPageSize {
+		query.Set("perpage", strconv.Itoa(o.PerPage))
+	}
+	return (&url.URL{Path: string(ctx.Path()), RawQuery: query.Encode()}).String()
+}
+
+// loadTemplates loads listing.html and style.css from config.TemplateDir,
+// the default template and style are used for the missing files
+func loadTemplates() (*template.Template, template.CSS, error) {
+	text := defaultListingTemplate
+	css := defaultListingCSS
+	if len(config.TemplateDir) > 0 {
+		if data, err := ioutil.ReadFile(filepath.Join(config.TemplateDir, "listing.html")); err == nil {
+			text = string(data)
+		} else if !fileOrDirIsExist(config.TemplateDir) {
+			return nil, "", err
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(config.TemplateDir, "style.css")); err == nil {
+			css = string(data)
+		}
+		log.Println("TemplateDir:", config.TemplateDir)
+	}
+	t, err := template.New("listing").Funcs(template.FuncMap{
+		"icon": fileIcon,
+		"join": pathpkg.Join,
+		"archive": func(entry dirEntry) bool {
+			return entry.Type == "file" && isArchiveName(entry.Name)
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, "", err
+	}
+	return t, template.CSS(css), nil
+}
+
+// fileIcon returns an emoji icon by the type and extension of entry
+func fileIcon(entry dirEntry) string {
+	if entry.Type == "dir" {
+		return "📁"
+	}
+	switch strings.ToLower(pathpkg.Ext(entry.Name)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".svg", ".webp", ".ico":
+		return "🖼️"
+	case ".mp4", ".mkv", ".avi", ".mov", ".webm":
+		return "🎞️"
+	case ".mp3", ".wav", ".flac", ".ogg", ".m4a":
+		return "🎵"
+	case ".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar":
+		return "📦"
+	case ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx":
+		return "📑"
+	}
+	return "📄"
+}
+
+const defaultListingCSS = `body{font-family:-apple-system,"Segoe UI",Helvetica,Arial,sans-serif;margin:1em;color:#222;background:#fff}
+table{width:100%;border-collapse:collapse}
+th,td{text-align:left;padding:2px 10px 2px 0}
+.size{text-align:right}
+a{text-decoration:none;color:#0366d6}
+tr:hover{background-color:#ffff99}
+.icon{display:inline-block;width:1.6em}
+nav{margin-bottom:.5em}
+@media (prefers-color-scheme:dark){
+body{color:#ddd;background:#1e1e1e}
+a{color:#58a6ff}
+tr:hover{background-color:#333}
+}`
+
+const defaultListingTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Title}}</title>
+<style>{{.CSS}}</style>
+</head>
+<body>
+<nav>{{range $i, $b := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$b.Link}}">{{$b.Name}}</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+{{- if .Upload}}
+<form enctype="multipart/form-data" action="/upload" method="post">
+<input name="files[]" type="file" multiple>
+<input type="submit" value="Upload" onclick="this.disabled=true;this.value='Sending...';">
+<label><input type="checkbox" name="o" value="true">Overwrite</label>
+<input type="hidden" id="r" name="r" value="{{.UploadURI}}">
+</form>
+{{- end}}
+{{- if .FileOps}}
+<p><button onclick="fileop('mkdir', {{.Path}})">New folder</button></p>
+{{- end}}
+<form method="get">
+<input name="filter" value="{{.Filter}}" placeholder="Filter, e.g. *.log">
+<input type="hidden" name="sort" value="{{.Sort}}"><input type="hidden" name="order" value="{{.Order}}">
+<input type="submit" value="Filter">
+</form>
+<p>{{.Total}} item(s){{if gt .Pages 1}}, page {{.Page}} of {{.Pages}}{{end}}
+{{- if .Archive}} | Download as <a href="?archive=zip">zip</a> <a href="?archive=tar.gz">tar.gz</a>{{end}}</p>
+<table>
+<tr><th><a href="{{index .SortLinks "name"}}">Name</a></th><th><a href="{{index .SortLinks "type"}}">Type</a></th><th>Mode</th>
+<th class="size"><a href="{{index .SortLinks "size"}}">Size</a></th><th><a href="{{index .SortLinks "mtime"}}">Modified</a></th>{{if .FileOps}}<th>Actions</th>{{end}}</tr>
+{{- if .Parent}}
+<tr><td><a href="{{.Parent}}"><span class="icon">⬆️</span><b>..</b></a></td></tr>
+{{- end}}
+{{- range .Items}}
+<tr><td><a href="{{.Link}}"><span class="icon">{{icon .}}</span>{{if eq .Type "dir"}}<b>{{.Name}}</b>{{else}}{{.Name}}{{end}}</a>
+{{- if archive .}} <a href="{{.Link}}/">(browse)</a>{{end}}</td>
+<td>{{.Type}}</td><td>{{.Mode}}</td><td class="size">{{if ne .Type "dir"}}{{.Size}}{{end}}</td><td>{{.MTime.Format "2006-01-02 15:04:05"}}</td>
+{{- if $.FileOps}}
+<td><a href="#" onclick="fileop('rename', {{join $.Path .Name}});return false">rename</a>
+<a href="#" onclick="fileop('move', {{join $.Path .Name}});return false">move</a>
+<a href="#" onclick="fileop('delete', {{join $.Path .Name}});return false">delete</a></td>
+{{- end}}</tr>
+{{- end}}
+</table>
+{{- if or .PrevLink .NextLink}}
+<p>{{if .PrevLink}}<a href="{{.PrevLink}}">&laquo; Prev</a>{{end}} {{if .NextLink}}<a href="{{.NextLink}}">Next &raquo;</a>{{end}}</p>
+{{- end}}
+{{- if .FileOps}}
+<script>
+function fileop(op, path) {
+  var body = {op: op, path: path};
+  if (op == "mkdir") {
+    var name = prompt("New folder name");
+    if (!name) return;
+    body.path = path.replace(/\/$/, "") + "/" + name;
+  } else if (op == "rename") {
+    body.name = prompt("Rename " + path + " to", path.substring(path.lastIndexOf("/") + 1));
+    if (!body.name) return;
+  } else if (op == "move") {
+    body.to = prompt("Move " + path + " to directory", path.substring(0, path.lastIndexOf("/")) || "/");
+    if (!body.to) return;
+  } else if (op == "delete" && !confirm("Delete " + path + "?")) {
+    return;
+  }
+  fetch({{.FileOpsPath}}, {method: "POST", credentials: "same-origin",
+    headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)})
+    .then(function (r) { return r.json(); })
+    .then(function (j) { if (j.error) { alert(j.error); } else { location.reload(); } });
+}
+</script>
+{{- end}}
+</body>
+</html>
+`
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// tusPath is the URI path of the resumable upload endpoint
+	tusPath = "/tus"
+	// tusVersion is the supported version of the tus protocol, see https://tus.io/protocols/resumable-upload.html
+	tusVersion = "1.0.0"
+	// tusDefaultExpiry is the default time to keep the partial uploads which are not changed
+	tusDefaultExpiry = 24 * time.Hour
+	// tusSweepInterval is the interval to remove the expired uploads
+	tusSweepInterval = 10 * time.Minute
+)
+
+// tusLocks serializes the requests of each upload
+var tusLocks sync.Map
+
+// tusUpload is the info of a resumable upload, saved as <id>.info beside the data file <id>.bin
+type tusUpload struct {
+	ID        string
+	Length    int64
+	Filename  string
+	Path      string
+	Overwrite bool
+	User      string
+	Created   time.Time
+}
+
+func (t *tusUpload) infoFile() string {
+	return filepath.Join(config.TusDir, t.ID+".info")
+}
+
+func (t *tusUpload) dataFile() string {
+	return filepath.Join(config.TusDir, t.ID+".bin")
+}
+
+// offset returns the size of received data
+func (t *tusUpload) offset() (int64, error) {
+	fi, err := os.Stat(t.dataFile())
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (t *tusUpload) save() error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.infoFile(), data, 0600)
+}
+
+func (t *tusUpload) remove() {
+	_ = os.Remove(t.dataFile())
+	_ = os.Remove(t.infoFile())
+}
+
+func loadTusUpload(id string) (*tusUpload, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) == 0 {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(filepath.Join(config.TusDir, id+".info"))
+	if err != nil {
+		return nil, err
+	}
+	t := &tusUpload{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// prepareTusDir creates the directory for the partial uploads
+func prepareTusDir() error {
+	if !config.EnableTus {
+		return nil
+	}
+	if len(config.TusDir) == 0 {
+		config.TusDir = filepath.Join(os.TempDir(), "simplehttpserver-tus")
+	}
+	return os.MkdirAll(config.TusDir, 0700)
+}
+
+// tusHandler implements the core protocol with creation and termination extensions of tus,
+// the metadata "filename" and "path" are required, "path" is the URI path of upload directory,
+// the finished upload is saved with the same overwrite rules as uploadHandle
+func tusHandler(ctx *fasthttp.RequestCtx, user string) {
+	ctx.Response.Header.Set("Tus-Resumable", tusVersion)
+	method := string(ctx.Method())
+	if method == "OPTIONS" {
+		ctx.Response.Header.Set("Tus-Version", tusVersion)
+		ctx.Response.Header.Set("Tus-Extension", "creation,termination,expiration")
+		setTusMaxSize(ctx)
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+	if string(ctx.Request.Header.Peek("Tus-Resumable")) != tusVersion {
+		ctx.Response.Header.Set("Tus-Version", tusVersion)
+		statusCode := fasthttp.StatusPreconditionFailed
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	if enableBasicAuth && len(user) == 0 {
+		unauthorized(ctx)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(string(ctx.Path()), tusPath), "/")
+	if len(id) == 0 {
+		if method == "POST" {
+			tusCreate(ctx, user)
+		} else {
+			statusCode := fasthttp.StatusMethodNotAllowed
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		}
+		return
+	}
+
+	t, err := loadTusUpload(id)
+	if err != nil || t.User != user {
+		statusCode := fasthttp.StatusNotFound
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	lock, _ := tusLocks.LoadOrStore(t.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	switch method {
+	case "HEAD":
+		offset, err := t.offset()
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		ctx.Response.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		ctx.Response.Header.Set("Upload-Length", strconv.FormatInt(t.Length, 10))
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+	case "PATCH":
+		tusPatch(ctx, t)
+	case "DELETE":
+		t.remove()
+		tusLocks.Delete(t.ID)
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	default:
+		statusCode := fasthttp.StatusMethodNotAllowed
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+	}
+}
+
+func tusCreate(ctx *fasthttp.RequestCtx, user string) {
+	length, err := strconv.ParseInt(string(ctx.Request.Header.Peek("Upload-Length")), 10, 64)
+	if err != nil || length < 0 {
+		ctx.Error("Invalid Upload-Length", fasthttp.StatusBadRequest)
+		return
+	}
+	if config.TusMaxSize > 0 && length > config.TusMaxSize {
+		ctx.Error("Upload-Length exceeds Tus-Max-Size", fasthttp.StatusRequestEntityTooLarge)
+		setTusMaxSize(ctx)
+		return
+	}
+	metadata := parseTusMetadata(string(ctx.Request.Header.Peek("Upload-Metadata")))
+	t := &tusUpload{
+		Length:    length,
+		Filename:  metadata["filename"],
+		Path:      pathpkg.Clean("/" + metadata["path"]),
+		Overwrite: metadata["overwrite"] == "true",
+		User:      user,
+		Created:   time.Now(),
+	}
+	if err := validateFilename(t.Filename); err != nil {
+		ctx.Error(fmt.Sprintf("%v: %q", err, t.Filename), fasthttp.StatusBadRequest)
+		return
+	}
+	if !authorize(ctx, t.Path, user, accessUpload) {
+		return
+	}
+	if isHiddenPath(pathpkg.Join(t.Path, t.Filename)) {
+		ctx.Error(fmt.Sprintf("%v: %q", errHidden, t.Filename), fasthttp.StatusForbidden)
+		return
+	}
+	rt, dir, err := tusTargetDir(t)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+	// do not overwrite the target of symlink
+	if err := rt.mount.checkSymlinks(filepath.Join(dir, t.Filename)); err != nil {
+		ctx.Error(fmt.Sprintf("%v: %q", err, t.Filename), fasthttp.StatusForbidden)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	t.ID = hex.EncodeToString(b)
+	if err := ioutil.WriteFile(t.dataFile(), nil, 0600); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if err := t.save(); err != nil {
+		t.remove()
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	logInfo(0, "%s | %s | Create resumable upload %s for %s/%s (%d bytes)",
+		ctx.RemoteIP(), user, t.ID, t.Path, t.Filename, t.Length)
+	ctx.Response.Header.Set("Location", tusPath+"/"+t.ID)
+	setTusExpires(ctx)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	if length == 0 {
+		tusFinish(ctx, t)
+	}
+}
+
+func tusPatch(ctx *fasthttp.RequestCtx, t *tusUpload) {
+	if string(ctx.Request.Header.ContentType()) != "application/offset+octet-stream" {
+		statusCode := fasthttp.StatusUnsupportedMediaType
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	offset, err := t.offset()
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if string(ctx.Request.Header.Peek("Upload-Offset")) != strconv.FormatInt(offset, 10) {
+		statusCode := fasthttp.StatusConflict
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	body := ctx.Request.Body()
+	if offset+int64(len(body)) > t.Length {
+		ctx.Error("The data exceeds Upload-Length", fasthttp.StatusBadRequest)
+		return
+	}
+	file, err := os.OpenFile(t.dataFile(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	n, err := file.Write(body)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	offset += int64(n)
+	ctx.Response.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+	if offset == t.Length {
+		tusFinish(ctx, t)
+		return
+	}
+	setTusExpires(ctx)
+}
+
+// tusFinish moves the finished upload into the upload directory
+func tusFinish(ctx *fasthttp.RequestCtx, t *tusUpload) {
+	rt, dir, err := tusTargetDir(t)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+	fn := filepath.Join(dir, t.Filename)
+	if !t.Overwrite && fileOrDirIsExist(fn) {
+		if fn, err = uniqueFilename(fn); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+	}
+	// the symlink may be created after tusCreate
+	if err := rt.mount.checkSymlinks(fn); err != nil {
+		ctx.Error(fmt.Sprintf("%v: %q", err, t.Filename), fasthttp.StatusForbidden)
+		return
+	}
+	logInfo(0, "%s | %s | Saving file %s", ctx.RemoteIP(), t.User, fn)
+	if err := moveFile(t.dataFile(), fn); err != nil {
+		logInfo(fasthttp.StatusInternalServerError, "Save %s failed: %s", fn, err.Error())
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	t.remove()
+	tusLocks.Delete(t.ID)
+}
+
+// tusTargetDir resolves the upload directory of t in the mapped paths
+func tusTargetDir(t *tusUpload) (*route, string, error) {
+	rt := routes.match(t.Path)
+	if rt == nil || !rt.mount.uploadEnabled() {
+		return nil, "", errors.New("upload is not enabled for " + t.Path)
+	}
+	dir, err := resolveLocalPath(rt, t.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	if !dirIsExist(dir) {
+		return nil, "", errors.New("the upload directory does not exist")
+	}
+	return rt, dir, nil
+}
+
+// setTusMaxSize sets the Tus-Max-Size header if config.TusMaxSize is given
+func setTusMaxSize(ctx *fasthttp.RequestCtx) {
+	if config.TusMaxSize > 0 {
+		ctx.Response.Header.Set("Tus-Max-Size", strconv.FormatInt(config.TusMaxSize, 10))
+	}
+}
+
+// setTusExpires sets the Upload-Expires header to the time when the upload expires if it is not changed
+func setTusExpires(ctx *fasthttp.RequestCtx) {
+	if config.TusExpiry > 0 {
+		ctx.Response.Header.Set("Upload-Expires", string(fasthttp.AppendHTTPDate(nil, time.Now().Add(config.TusExpiry))))
+	}
+}
+
+// sweepTusUploads removes the expired uploads every tusSweepInterval
+func sweepTusUploads() {
+	for {
+		removeExpiredTusUploads()
+		time.Sleep(tusSweepInterval)
+	}
+}
+
+// removeExpiredTusUploads removes the data and info files of the uploads which are not changed in config.TusExpiry
+func removeExpiredTusUploads() {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	if !config.EnableTus || config.TusExpiry <= 0 {
+		return
+	}
+	ff, err := ioutil.ReadDir(config.TusDir)
+	if err != nil {
+		return
+	}
+	// the last changed time of each upload
+	changed := make(map[string]time.Time)
+	for _, f := range ff {
+		ext := filepath.Ext(f.Name())
+		id := strings.TrimSuffix(f.Name(), ext)
+		if ext != ".bin" && ext != ".info" || len(id) == 0 {
+			continue
+		}
+		if _, err := hex.DecodeString(id); err != nil {
+			continue
+		}
+		if f.ModTime().After(changed[id]) {
+			changed[id] = f.ModTime()
+		}
+	}
+	for id, mtime := range changed {
+		if time.Since(mtime) < config.TusExpiry {
+			continue
+		}
+		lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		t := &tusUpload{ID: id}
+		if fi, err := os.Stat(t.dataFile()); err != nil || time.Since(fi.ModTime()) >= config.TusExpiry {
+			t.remove()
+			logInfo(0, "Remove expired resumable upload %s", id)
+		}
+		lock.(*sync.Mutex).Unlock()
+		tusLocks.Delete(id)
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header like "key base64value,key base64value"
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if len(kv[0]) == 0 {
+			continue
+		}
+		var value string
+		if len(kv) == 2 {
+			if b, err := base64.StdEncoding.DecodeString(kv[1]); err == nil {
+				value = string(b)
+			}
+		}
+		metadata[kv[0]] = value
+	}
+	return metadata
+}
+
+// moveFile renames src to dst, or copies it to a temp file beside dst then renames the temp file
+// if they are on different devices, dst is replaced rather than written through if it is a symlink.
+// The mode of dst is 0644 like the other uploads, src is private in config.TusDir.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return os.Chmod(dst, 0644)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		in.Close()
+		return err
+	}
+	tmp := out.Name()
+	_, err = io.Copy(out, in)
+	in.Close()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func printTus() {
+	if config.EnableTus {
+		log.Printf("Resumable upload: %s, TusDir: %s\n", tusPath, config.TusDir)
+		if config.TusMaxSize > 0 {
+			log.Println("TusMaxSize:", config.TusMaxSize)
+		}
+		log.Println("TusExpiry:", config.TusExpiry)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	errInvalidFilename = errors.New("invalid filename")
+	errOutsideRoot     = errors.New("path is outside the root")
+	errBodyTooLarge    = errors.New("body size exceeds the given limit")
+)
+
+// uploadHandle saves the files of the multipart form to the directory of URI path r,
+// the directory is resolved from the mapped paths rather than trusting the client
+func uploadHandle(ctx *fasthttp.RequestCtx, user string) {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	var uri string
+	isOverwrite := false
+	if r, ok := form.Value["r"]; ok && len(r) == 1 {
+		uri = r[0]
+	}
+	if len(uri) == 0 {
+		ctx.Error("Missing the URI of upload directory", fasthttp.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	upath := pathpkg.Clean("/" + u.Path)
+	if !authorize(ctx, upath, user, accessUpload) {
+		return
+	}
+	rt := routes.match(upath)
+	if rt == nil || !rt.mount.uploadEnabled() {
+		statusCode := fasthttp.StatusForbidden
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	if ctx.Request.Header.ContentLength() > rt.mount.maxRequestBodySize() {
+		statusCode := fasthttp.StatusRequestEntityTooLarge
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	dir, err := resolveLocalPath(rt, upath)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+	if !dirIsExist(dir) {
+		ctx.Error("The upload directory does not exist", fasthttp.StatusNotFound)
+		return
+	}
+	if o, ok := form.Value["o"]; ok && len(o) == 1 {
+		isOverwrite = o[0] == "true"
+	}
+	// check all filenames before saving any file
+	for _, v := range form.File {
+		for _, header := range v {
+			if err := validateFilename(header.Filename); err != nil {
+				ctx.Error(fmt.Sprintf("%v: %q", err, header.Filename), fasthttp.StatusBadRequest)
+				return
+			}
+			if rt.mount.isHidden(pathpkg.Join(rt.rel(upath), header.Filename)) {
+				ctx.Error(fmt.Sprintf("%v: %q", errHidden, header.Filename), fasthttp.StatusForbidden)
+				return
+			}
+			// do not overwrite the target of symlink
+			if err := rt.mount.checkSymlinks(filepath.Join(dir, header.Filename)); err != nil {
+				ctx.Error(fmt.Sprintf("%v: %q", err, header.Filename), fasthttp.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	for _, v := range form.File {
+		for _, header := range v {
+			fn := filepath.Join(dir, header.Filename)
+			if !isOverwrite && fileOrDirIsExist(fn) {
+				if fn, err = uniqueFilename(fn); err != nil {
+					ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+					return
+				}
+			}
+			logInfo(0, "%s | %s | Saving file %s", ctx.RemoteIP(), user, fn)
+			err := fasthttp.SaveMultipartFile(header, fn)
+			if err != nil {
+				logInfo(fasthttp.StatusInternalServerError, "Save %s failed: %s", fn, err.Error())
+			}
+		}
+	}
+	// redirect to the path only, r may be an absolute URL of other site
+	ctx.Redirect(u.EscapedPath(), fasthttp.StatusOK)
+}
+
+// resolveLocalPath returns the local path of URI path in the root of rt,
+// the ".." elements can not go up beyond the root,
+// and the hidden paths and the symlinks not allowed by the policy can not be resolved
+func resolveLocalPath(rt *route, path string) (string, error) {
+	rel := pathpkg.Clean("/" + rt.rel(path))
+	if rt.mount.isHidden(rel) {
+		return "", errHidden
+	}
+	local := filepath.Join(rt.mount.Root, filepath.FromSlash(rel))
+	if !isWithin(rt.mount.Root, local) {
+		return "", errOutsideRoot
+	}
+	if err := rt.mount.checkSymlinks(local); err != nil {
+		return "", err
+	}
+	return local, nil
+}
+
+// isWithin reports whether path is root or below root
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// validateFilename rejects the names which are not a single path element,
+// e.g. "../x", "/etc/passwd", "C:\x" or "a/b"
+func validateFilename(name string) error {
+	if len(name) == 0 || name == "." || name == ".." ||
+		strings.ContainsAny(name, "/\\\x00") ||
+		filepath.IsAbs(name) || len(filepath.VolumeName(name)) > 0 {
+		return errInvalidFilename
+	}
+	return nil
+}
+
+// uniqueFilename returns an unused filename like name_20060102150405_1.ext
+func uniqueFilename(fn string) (string, error) {
+	ext := filepath.Ext(fn)
+	for index := 1; index <= MaxInt; index++ {
+		newfn := fmt.Sprintf("%s_%s_%d%s",
+			strings.TrimSuffix(fn, ext),
+			time.Now().Format("20060102150405"),
+			index,
+			ext)
+		if !fileOrDirIsExist(newfn) {
+			return newfn, nil
+		}
+		if index == MaxInt {
+			break
+		}
+	}
+	return "", fmt.Errorf("Sorry, can not create unique filename for %s", fn)
+}
+
+// putHandle saves the request body to the URI path, e.g.:
+//
+//	curl -T artifact.tar http://localhost:8080/builds/artifact.tar
+//
+// An existing file is renamed like uploadHandle,
+// unless the query overwrite=true or the header "Overwrite: T" is given.
+func putHandle(ctx *fasthttp.RequestCtx, user string) {
+	path := pathpkg.Clean("/" + string(ctx.Path()))
+	if !authorize(ctx, path, user, accessUpload) {
+		return
+	}
+	rt := routes.match(path)
+	if rt == nil || !rt.mount.uploadEnabled() {
+		statusCode := fasthttp.StatusForbidden
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	if ctx.Request.Header.ContentLength() > rt.mount.maxRequestBodySize() {
+		statusCode := fasthttp.StatusRequestEntityTooLarge
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	fn, err := resolveLocalPath(rt, path)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusForbidden)
+		return
+	}
+	dir, name := filepath.Split(fn)
+	if err := validateFilename(name); err != nil || fn == filepath.Clean(rt.mount.Root) {
+		ctx.Error("The URI path should be a file", fasthttp.StatusBadRequest)
+		return
+	}
+	if !dirIsExist(dir) || dirIsExist(fn) {
+		statusCode := fasthttp.StatusConflict
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	isOverwrite := string(ctx.QueryArgs().Peek("overwrite")) == "true" ||
+		string(ctx.Request.Header.Peek("Overwrite")) == "T"
+	existed := fileIsExist(fn)
+	if existed && !isOverwrite {
+		if fn, err = uniqueFilename(fn); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+	}
+
+	logInfo(0, "%s | %s | Saving file %s", ctx.RemoteIP(), user, fn)
+	if err := saveBody(ctx, fn, rt.mount.maxRequestBodySize()); err == errBodyTooLarge {
+		statusCode := fasthttp.StatusRequestEntityTooLarge
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	} else if err != nil {
+		logInfo(fasthttp.StatusInternalServerError, "Save %s failed: %s", fn, err.Error())
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if existed && isOverwrite {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+		return
+	}
+	location := &url.URL{Path: pathpkg.Join(pathpkg.Dir(path), filepath.Base(fn))}
+	ctx.Response.Header.Set("Location", location.EscapedPath())
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+}
+
+// saveBody streams the request body to a temp file beside fn then renames it to fn,
+// so a failed upload does not leave a partial file or break the overwritten file.
+// It returns errBodyTooLarge if the body is larger than limit.
+func saveBody(ctx *fasthttp.RequestCtx, fn string, limit int) error {
+	body := ctx.RequestBodyStream()
+	if body == nil {
+		// the small body has been read by fasthttp
+		body = bytes.NewReader(ctx.Request.Body())
+	}
+	file, err := ioutil.TempFile(filepath.Dir(fn), ".upload-*")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	n, err := io.Copy(file, io.LimitReader(body, int64(limit)+1))
+	if err == nil && n > int64(limit) {
+		err = errBodyTooLarge
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"..a", true},
+		{"a b.txt", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../x", false},
+		{"..\\x", false},
+		{"/etc/passwd", false},
+		{"C:\\x", false},
+		{"a/b", false},
+		{"a\\b", false},
+		{"a\x00.txt", false},
+	}
+	for _, tt := range tests {
+		err := validateFilename(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("validateFilename(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && err != errInvalidFilename {
+			t.Errorf("validateFilename(%q) = %v, want %v", tt.name, err, errInvalidFilename)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want bool
+	}{
+		{"/srv/share", "/srv/share", true},
+		{"/srv/share", "/srv/share/a", true},
+		{"/srv/share", "/srv/share/a/../b", true},
+		{"/srv/share", "/srv/share/..a", true},
+		{"/srv/share", "/srv", false},
+		{"/srv/share", "/srv/share/../x", false},
+		{"/srv/share", "/srv/sharex", false},
+		{"/srv/share", "/etc/passwd", false},
+		{"share", "share/a", true},
+		{"share", "share/../etc", false},
+		{"share", "/etc", false},
+		{".", "a", true},
+		{".", "../a", false},
+	}
+	for _, tt := range tests {
+		if got := isWithin(tt.root, tt.path); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLocalPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "simplehttpserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	tests := []struct {
+		root   string
+		prefix string
+		path   string
+		want   string
+		err    error
+	}{
+		{root, "/", "/", root, nil},
+		{root, "/", "/a.txt", filepath.Join(root, "a.txt"), nil},
+		{root, "/", "/../../etc", filepath.Join(root, "etc"), nil},
+		{root, "/", "/a/../../etc/passwd", filepath.Join(root, "etc", "passwd"), nil},
+		{root, "/docs", "/docs/../../etc", filepath.Join(root, "etc"), nil},
+		{root, "/docs", "/docs/sub/a.txt", filepath.Join(root, "sub", "a.txt"), nil},
+		{root, "/", "/.git/config", "", errHidden},
+		// the root without "./" is relative to the working directory
+		{"share", "/", "/../../etc", filepath.Join("share", "etc"), nil},
+		{"share", "/", "/a/b", filepath.Join("share", "a", "b"), nil},
+	}
+	for _, tt := range tests {
+		r := &router{}
+		rt := r.add(tt.prefix, &Mount{Root: tt.root}, nil)
+		got, err := resolveLocalPath(rt, tt.path)
+		if err != tt.err {
+			t.Errorf("resolveLocalPath(%q, %q) error = %v, want %v", tt.root, tt.path, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveLocalPath(%q, %q) = %q, want %q", tt.root, tt.path, got, tt.want)
+		}
+		if err == nil && !isWithin(tt.root, got) {
+			t.Errorf("resolveLocalPath(%q, %q) = %q is outside the root", tt.root, tt.path, got)
+		}
+	}
+}
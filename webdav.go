@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/net/webdav"
+)
+
+// newDavHandler returns a WebDAV handler serving the root of m at URI path prefix,
+// the hidden files and the symlinks not allowed of m can not be accessed,
+// and each file is checked by the access rules for the user of the request
+func newDavHandler(prefix string, m *Mount) func(ctx *fasthttp.RequestCtx, user string) {
+	if prefix == "/" {
+		prefix = ""
+	}
+	ls := webdav.NewMemLS()
+	logger := func(r *http.Request, err error) {
+		if err != nil && config.Verbose {
+			logInfo(fasthttp.StatusInternalServerError, "WebDAV %s %s failed: %v\n", r.Method, r.URL.Path, err)
+		}
+	}
+	return func(ctx *fasthttp.RequestCtx, user string) {
+		fasthttpadaptor.NewFastHTTPHandler(&webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: &mountFS{FileSystem: webdav.Dir(m.Root), mount: m, prefix: prefix, user: user},
+			LockSystem: ls,
+			Logger:     logger,
+		})(ctx)
+	}
+}
+
+// isDavPath reports whether path is in a path enabled WebDAV
+func isDavPath(path string) bool {
+	rt := routes.match(path)
+	return rt != nil && rt.dav != nil
+}
+
+// davHandler serves the WebDAV methods on the paths enabled WebDAV,
+// PROPFIND and OPTIONS need the read access, DELETE and MOVE need the manage access,
+// other methods need the upload access. COPY and MOVE also need the manage access
+// of the destination if it may be overwritten. The files below the request path are checked by mountFS.
+func davHandler(ctx *fasthttp.RequestCtx, user string) {
+	path := string(ctx.Path())
+	rt := routes.match(path)
+	if rt == nil || rt.dav == nil {
+		statusCode := fasthttp.StatusNotFound
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+
+	mode := accessUpload
+	switch string(ctx.Method()) {
+	case "PROPFIND", "OPTIONS", "HEAD":
+		mode = accessRead
+	case "DELETE", "MOVE":
+		mode = accessManage
+	}
+	if !authorize(ctx, path, user, mode) {
+		return
+	}
+	// DELETE and MOVE remove the directory tree, see mountFS.RemoveAll and mountFS.Rename
+	if mode == accessManage && (!isTreeAllowed(path, user, accessRead) || !isTreeAllowed(path, user, accessManage)) {
+		statusCode := fasthttp.StatusForbidden
+		ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+		return
+	}
+	if mode != accessRead {
+		if !rt.mount.uploadEnabled() {
+			statusCode := fasthttp.StatusForbidden
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+			return
+		}
+		if ctx.Request.Header.ContentLength() > rt.mount.maxRequestBodySize() {
+			statusCode := fasthttp.StatusRequestEntityTooLarge
+			ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+			return
+		}
+		// MOVE and COPY also write to the destination
+		if dest := ctx.Request.Header.Peek("Destination"); len(dest) > 0 {
+			u, err := url.Parse(string(dest))
+			if err != nil {
+				ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+				return
+			}
+			destMode := accessUpload
+			// the default of Overwrite header is T
+			if string(ctx.Request.Header.Peek("Overwrite")) != "F" {
+				destMode = accessManage
+			}
+			destPath := pathpkg.Clean("/" + u.Path)
+			if !authorize(ctx, destPath, user, destMode) {
+				return
+			}
+			// the copy would recurse into itself
+			if pathHasPrefix(destPath, pathpkg.Clean(path)) {
+				ctx.Error("Can not copy or move a directory into itself", fasthttp.StatusForbidden)
+				return
+			}
+		}
+	}
+	rt.dav(ctx, user)
+}
+
+// mountFS is the WebDAV file system of mount for user,
+// the methods working on a directory tree check the access rules below it
+type mountFS struct {
+	webdav.FileSystem
+	mount  *Mount
+	prefix string
+	user   string
+}
+
+// allowed reports whether the hidden rules and symlink policy allow to access name
+func (fs *mountFS) allowed(name string) bool {
+	local := filepath.Join(fs.mount.Root, filepath.FromSlash(pathpkg.Clean("/"+name)))
+	return !fs.mount.isHidden(name) && fs.mount.checkSymlinks(local) == nil
+}
+
+// uriPath returns the URI path of name
+func (fs *mountFS) uriPath(name string) string {
+	return pathpkg.Join("/", fs.prefix, name)
+}
+
+func (fs *mountFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !fs.allowed(name) || !isAllowed(fs.uriPath(name), fs.user, accessUpload) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *mountFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if !fs.allowed(name) {
+		return nil, os.ErrNotExist
+	}
+	mode := accessRead
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		mode = accessUpload
+	}
+	if !isAllowed(fs.uriPath(name), fs.user, mode) {
+		return nil, os.ErrPermission
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &mountFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *mountFS) RemoveAll(ctx context.Context, name string) error {
+	if !fs.allowed(name) {
+		return os.ErrNotExist
+	}
+	if !isTreeAllowed(fs.uriPath(name), fs.user, accessManage) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename moves the files below oldName out of their access rules,
+// so the user should be able to read and manage all of them
+func (fs *mountFS) Rename(ctx context.Context, oldName, newName string) error {
+	if !fs.allowed(oldName) {
+		return os.ErrNotExist
+	}
+	if !isTreeAllowed(fs.uriPath(oldName), fs.user, accessRead) ||
+		!isTreeAllowed(fs.uriPath(oldName), fs.user, accessManage) {
+		return os.ErrPermission
+	}
+	if !fs.allowed(newName) || !isAllowed(fs.uriPath(newName), fs.user, accessUpload) {
+		return os.ErrPermission
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *mountFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.allowed(name) {
+		return nil, os.ErrNotExist
+	}
+	if !isAllowed(fs.uriPath(name), fs.user, accessRead) {
+		return nil, os.ErrPermission
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+// mountFile removes the hidden files, the symlinks not allowed
+// and the files the user can not read from the directory
+type mountFile struct {
+	webdav.File
+	fs   *mountFS
+	name string
+}
+
+func (f *mountFile) Readdir(count int) ([]os.FileInfo, error) {
+	ff, err := f.File.Readdir(count)
+	m := f.fs.mount
+	ff = filterHidden(m, f.name, ff)
+	ff = filterSymlinks(m, filepath.Join(m.Root, filepath.FromSlash(pathpkg.Clean("/"+f.name))), ff)
+	allowed := ff[:0]
+	for _, fi := range ff {
+		if isAllowed(f.fs.uriPath(pathpkg.Join(f.name, fi.Name())), f.fs.user, accessRead) {
+			allowed = append(allowed, fi)
+		}
+	}
+	return allowed, err
+}